@@ -0,0 +1,289 @@
+package experimental
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Sirupsen/logrus"
+)
+
+const azureCopyPollInterval = time.Second
+
+type azureStorage struct {
+	*distributionStorageAzure
+	*azureStats
+	container *container.Client
+	backup    bool
+}
+
+type azureStats struct {
+	storageCache
+	apiCalls          int64
+	expensiveApiCalls int64
+	freeApiCalls      int64
+}
+
+func (f *azureStorage) fullPath(path string) string {
+	if f.backup {
+		return f.backupPath(path)
+	}
+	return f.livePath(path)
+}
+
+func (f *azureStorage) livePath(path string) string {
+	return strings.TrimPrefix(filepath.Join(f.RootDirectory, "docker", "registry", "v2", path), "/")
+}
+
+func (f *azureStorage) backupPath(path string) string {
+	return strings.TrimPrefix(filepath.Join(backupRoot(f.RootDirectory), "docker-backup", "registry", "v2", path), "/")
+}
+
+func azureFileInfo(item *container.BlobItem) fileInfo {
+	fi := fileInfo{fullPath: *item.Name}
+	if properties := item.Properties; properties != nil {
+		if properties.ContentLength != nil {
+			fi.size = *properties.ContentLength
+		}
+		if properties.LastModified != nil {
+			fi.lastModified = *properties.LastModified
+		}
+		// Content-MD5 is set only for blobs uploaded with single request
+		if len(properties.ContentMD5) > 0 {
+			fi.etag = "\"" + hex.EncodeToString(properties.ContentMD5) + "\""
+		}
+	}
+	return fi
+}
+
+func (f *azureStorage) Walk(path string, baseDir string, fn walkFunc) error {
+	path = f.fullPath(path) + "/"
+	baseDir = f.fullPath(baseDir) + "/"
+
+	pager := f.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:     to.Ptr(path),
+		MaxResults: to.Ptr(int32(listMax)),
+	})
+
+	for pager.More() {
+		atomic.AddInt64(&f.apiCalls, 1)
+		resp, err := pager.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+
+		for _, item := range resp.Segment.BlobItems {
+			keyPath := *item.Name
+			if strings.HasPrefix(keyPath, baseDir) {
+				keyPath = keyPath[len(baseDir):]
+			}
+
+			if keyPath == "" {
+				continue
+			}
+
+			if strings.HasSuffix(keyPath, "/") {
+				logrus.Debugln("Azure Walk:", keyPath, "for", baseDir)
+				continue
+			}
+
+			err = fn(keyPath, azureFileInfo(item), nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *azureStorage) List(path string, fn walkFunc) error {
+	path = f.fullPath(path) + "/"
+
+	pager := f.container.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+		Prefix:     to.Ptr(path),
+		MaxResults: to.Ptr(int32(listMax)),
+	})
+
+	for pager.More() {
+		atomic.AddInt64(&f.apiCalls, 1)
+		resp, err := pager.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+
+		for _, item := range resp.Segment.BlobItems {
+			keyPath := strings.TrimPrefix(*item.Name, path)
+			if keyPath == "" {
+				continue
+			}
+
+			err = fn(keyPath, azureFileInfo(item), nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, prefix := range resp.Segment.BlobPrefixes {
+			prefixPath := strings.TrimPrefix(*prefix.Name, path)
+			if prefixPath == "" {
+				continue
+			}
+
+			fi := fileInfo{
+				fullPath:  *prefix.Name,
+				directory: true,
+			}
+
+			err = fn(prefixPath, fi, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *azureStorage) Read(path string, etag string) ([]byte, error) {
+	if data := f.readCache(path, etag); data != nil {
+		return data, nil
+	}
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	resp, err := f.container.NewBlobClient(f.fullPath(path)).DownloadStream(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.writeCache(path, etag, data)
+	return data, nil
+}
+
+func (f *azureStorage) Delete(path string) error {
+	atomic.AddInt64(&f.freeApiCalls, 1)
+	_, err := f.container.NewBlobClient(f.fullPath(path)).Delete(context.Background(), nil)
+	return err
+}
+
+// copy waits for server-side copy, as large blobs are copied asynchronously,
+// exclusive copy fails when destination exists
+func (f *azureStorage) copy(source, destination string, exclusive bool) error {
+	target := f.container.NewBlobClient(destination)
+
+	var options *blob.StartCopyFromURLOptions
+	if exclusive {
+		options = &blob.StartCopyFromURLOptions{
+			AccessConditions: &blob.AccessConditions{
+				ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)},
+			},
+		}
+	}
+
+	atomic.AddInt64(&f.expensiveApiCalls, 1)
+	resp, err := target.StartCopyFromURL(context.Background(), f.container.NewBlobClient(source).URL(), options)
+	if exclusive && bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet, bloberror.TargetConditionNotMet) {
+		return &os.PathError{Op: "copy", Path: destination, Err: os.ErrExist}
+	} else if err != nil {
+		return err
+	}
+
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		time.Sleep(azureCopyPollInterval)
+
+		atomic.AddInt64(&f.apiCalls, 1)
+		properties, err := target.GetProperties(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		status = properties.CopyStatus
+	}
+
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return fmt.Errorf("copy of %s to %s finished with status: %s", source, destination, *status)
+	}
+	return nil
+}
+
+func (f *azureStorage) Move(path, newPath string) error {
+	return f.move(path, newPath, false)
+}
+
+func (f *azureStorage) MoveExclusive(path, newPath string) error {
+	return f.move(path, newPath, true)
+}
+
+func (f *azureStorage) move(path, newPath string, exclusive bool) error {
+	// Moving out of backup restores data
+	if f.backup {
+		newPath = f.livePath(newPath)
+	} else {
+		newPath = f.backupPath(newPath)
+	}
+
+	err := f.copy(f.fullPath(path), newPath, exclusive)
+	if err != nil {
+		return err
+	}
+	return f.Delete(path)
+}
+
+func (f *azureStorage) Backup() storageObject {
+	backup := *f
+	backup.backup = true
+	return &backup
+}
+
+func (f *azureStorage) Info() {
+	logrus.Infoln("AZURE INFO: API calls/expensive/free:", f.apiCalls, f.expensiveApiCalls, f.freeApiCalls,
+		"Cache (hit/miss/error):", f.cacheHits, f.cacheMiss, f.cacheError)
+}
+
+func (c *distributionStorageAzure) containerURL() string {
+	serviceURL := c.ServiceURL
+	if serviceURL == "" {
+		realm := c.Realm
+		if realm == "" {
+			realm = "core.windows.net"
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.%s", c.AccountName, realm)
+	}
+	return strings.TrimSuffix(serviceURL, "/") + "/" + c.Container
+}
+
+func newAzureStorage(config *distributionStorageAzure) (storageObject, error) {
+	credential, err := container.NewSharedKeyCredential(config.AccountName, config.AccountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := container.NewClientWithSharedKeyCredential(config.containerURL(), credential, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &azureStorage{
+		distributionStorageAzure: config,
+		azureStats:               &azureStats{},
+		container:                client,
+	}
+	return storage, nil
+}
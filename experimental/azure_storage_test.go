@@ -0,0 +1,85 @@
+package experimental
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// Well-known account of Azurite emulator
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// TestAzureStorage runs against Azurite, started with:
+// azurite-blob and AZURITE_SERVICE_URL=http://127.0.0.1:10000/devstoreaccount1
+func TestAzureStorage(t *testing.T) {
+	serviceURL := os.Getenv("AZURITE_SERVICE_URL")
+	if serviceURL == "" {
+		t.Skip("AZURITE_SERVICE_URL is not set")
+	}
+
+	storage, err := newAzureStorage(&distributionStorageAzure{
+		AccountName:   azuriteAccountName,
+		AccountKey:    azuriteAccountKey,
+		Container:     "registry",
+		ServiceURL:    serviceURL,
+		RootDirectory: fmt.Sprintf("test-%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	azure := storage.(*azureStorage)
+	_, err = azure.container.Create(context.Background(), nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		t.Fatal(err)
+	}
+
+	testStorage(t, storage, func(path string, data []byte) error {
+		_, err := azure.container.NewBlockBlobClient(azure.livePath(path)).UploadBuffer(context.Background(), data, nil)
+		return err
+	})
+}
+
+func TestAzureStorageMoveExclusive(t *testing.T) {
+	var conditions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("x-ms-copy-source") == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		conditions = append(conditions, r.Header.Get("If-None-Match"))
+		w.Header().Set("x-ms-error-code", string(bloberror.BlobAlreadyExists))
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	storage, err := newAzureStorage(&distributionStorageAzure{
+		AccountName: azuriteAccountName,
+		AccountKey:  azuriteAccountKey,
+		Container:   "registry",
+		ServiceURL:  server.URL + "/" + azuriteAccountName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link"
+	err = storage.Backup().(exclusiveStorage).MoveExclusive("backup/"+path, path)
+	if !os.IsExist(err) {
+		t.Fatal("expected existing blob to be reported, got:", err)
+	}
+
+	if len(conditions) != 1 || conditions[0] != "*" {
+		t.Fatal("expected exclusive copy to require destination not to exist, got:", conditions)
+	}
+}
@@ -7,6 +7,7 @@ type blobData struct {
 	size       int64
 	references int64
 	etag       string
+	recent     bool
 }
 
 func (b *blobData) path() string {
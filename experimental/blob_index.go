@@ -0,0 +1,71 @@
+package experimental
+
+import (
+	"flag"
+	"sync"
+)
+
+var blobIndexDir = flag.String("blob-index-dir", "", "Directory in which index of blobs and repository links is kept on disk, instead of memory")
+
+// blobIndex keeps all blobs of registry and their reference counts
+type blobIndex interface {
+	add(blob *blobData) error
+	get(digest digest) (*blobData, error)
+	// mark adds reference to blob, returns false if blob does not exist
+	mark(digest digest) (bool, error)
+	each(fn func(blob *blobData) error) error
+	close() error
+}
+
+type memoryBlobIndex struct {
+	blobs map[digest]*blobData
+	lock  sync.Mutex
+}
+
+func (i *memoryBlobIndex) add(blob *blobData) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.blobs[blob.name] = blob
+	return nil
+}
+
+func (i *memoryBlobIndex) get(digest digest) (*blobData, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.blobs[digest], nil
+}
+
+func (i *memoryBlobIndex) mark(digest digest) (bool, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	blob := i.blobs[digest]
+	if blob == nil {
+		return false, nil
+	}
+	blob.references++
+	return true, nil
+}
+
+func (i *memoryBlobIndex) each(fn func(blob *blobData) error) error {
+	for _, blob := range i.blobs {
+		err := fn(blob)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *memoryBlobIndex) close() error {
+	return nil
+}
+
+func newBlobIndex() (blobIndex, error) {
+	if *blobIndexDir != "" {
+		return newBoltBlobIndex(*blobIndexDir)
+	}
+	return &memoryBlobIndex{blobs: make(map[digest]*blobData)}, nil
+}
@@ -0,0 +1,254 @@
+package experimental
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const boltBlobIndexBatch = 10000
+
+var boltBlobsBucket = []byte("blobs")
+
+// boltBlobIndex keeps blobs in a temporary bolt database,
+// changes are collected in memory and written in batches
+type boltBlobIndex struct {
+	db   *bolt.DB
+	path string
+
+	// lock is held for reading by lookups and for writing by flush,
+	// so that lookups see every change either pending or committed
+	lock sync.RWMutex
+
+	pendingLock       sync.Mutex
+	pendingBlobs      map[digest]*blobData
+	pendingReferences map[digest]int64
+}
+
+func encodeBlob(blob *blobData) []byte {
+	value := make([]byte, 17+len(blob.etag))
+	binary.BigEndian.PutUint64(value[0:8], uint64(blob.size))
+	binary.BigEndian.PutUint64(value[8:16], uint64(blob.references))
+	if blob.recent {
+		value[16] = 1
+	}
+	copy(value[17:], blob.etag)
+	return value
+}
+
+func decodeBlob(key, value []byte) (*blobData, error) {
+	if len(key) != len(digest{}.hash) || len(value) < 17 {
+		return nil, fmt.Errorf("corrupted blob index entry: %x", key)
+	}
+
+	blob := &blobData{
+		size:       int64(binary.BigEndian.Uint64(value[0:8])),
+		references: int64(binary.BigEndian.Uint64(value[8:16])),
+		recent:     value[16] == 1,
+		etag:       string(value[17:]),
+	}
+	copy(blob.name.hash[:], key)
+	return blob, nil
+}
+
+// flush writes pending changes, lock has to be held for writing
+func (i *boltBlobIndex) flush() error {
+	if len(i.pendingBlobs) == 0 && len(i.pendingReferences) == 0 {
+		return nil
+	}
+
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBlobsBucket)
+
+		for _, blob := range i.pendingBlobs {
+			err := bucket.Put(blob.name.hash[:], encodeBlob(blob))
+			if err != nil {
+				return err
+			}
+		}
+
+		for digest, references := range i.pendingReferences {
+			value := bucket.Get(digest.hash[:])
+			if value == nil {
+				continue
+			}
+
+			blob, err := decodeBlob(digest.hash[:], value)
+			if err != nil {
+				return err
+			}
+
+			blob.references += references
+			err = bucket.Put(digest.hash[:], encodeBlob(blob))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	i.pendingBlobs = make(map[digest]*blobData)
+	i.pendingReferences = make(map[digest]int64)
+	return err
+}
+
+func (i *boltBlobIndex) flushAll() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.flush()
+}
+
+func (i *boltBlobIndex) flushBatch() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	// other caller could flush the batch already
+	if len(i.pendingBlobs) < boltBlobIndexBatch && len(i.pendingReferences) < boltBlobIndexBatch {
+		return nil
+	}
+	return i.flush()
+}
+
+// lookup reads committed blob, lock has to be held
+func (i *boltBlobIndex) lookup(digest digest) (*blobData, error) {
+	var blob *blobData
+
+	err := i.db.View(func(tx *bolt.Tx) (err error) {
+		value := tx.Bucket(boltBlobsBucket).Get(digest.hash[:])
+		if value != nil {
+			blob, err = decodeBlob(digest.hash[:], value)
+		}
+		return err
+	})
+	return blob, err
+}
+
+func (i *boltBlobIndex) add(blob *blobData) error {
+	i.lock.RLock()
+	i.pendingLock.Lock()
+	i.pendingBlobs[blob.name] = blob
+	full := len(i.pendingBlobs) >= boltBlobIndexBatch
+	i.pendingLock.Unlock()
+	i.lock.RUnlock()
+
+	if !full {
+		return nil
+	}
+	return i.flushBatch()
+}
+
+func (i *boltBlobIndex) get(digest digest) (*blobData, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	i.pendingLock.Lock()
+	pending := i.pendingBlobs[digest]
+	references := i.pendingReferences[digest]
+	i.pendingLock.Unlock()
+
+	if pending != nil {
+		blob := *pending
+		blob.references += references
+		return &blob, nil
+	}
+
+	blob, err := i.lookup(digest)
+	if err != nil || blob == nil {
+		return nil, err
+	}
+	blob.references += references
+	return blob, nil
+}
+
+func (i *boltBlobIndex) mark(digest digest) (bool, error) {
+	i.lock.RLock()
+
+	i.pendingLock.Lock()
+	_, found := i.pendingBlobs[digest]
+	if !found {
+		// only existing blobs have pending references
+		_, found = i.pendingReferences[digest]
+	}
+	i.pendingLock.Unlock()
+
+	if !found {
+		blob, err := i.lookup(digest)
+		if err != nil || blob == nil {
+			i.lock.RUnlock()
+			return false, err
+		}
+	}
+
+	i.pendingLock.Lock()
+	i.pendingReferences[digest]++
+	full := len(i.pendingReferences) >= boltBlobIndexBatch
+	i.pendingLock.Unlock()
+	i.lock.RUnlock()
+
+	if !full {
+		return true, nil
+	}
+	return true, i.flushBatch()
+}
+
+func (i *boltBlobIndex) each(fn func(blob *blobData) error) error {
+	err := i.flushAll()
+	if err != nil {
+		return err
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	return i.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlobsBucket).ForEach(func(key, value []byte) error {
+			blob, err := decodeBlob(key, value)
+			if err != nil {
+				return err
+			}
+			return fn(blob)
+		})
+	})
+}
+
+func (i *boltBlobIndex) close() error {
+	err := i.db.Close()
+	os.Remove(i.path)
+	return err
+}
+
+func newBoltBlobIndex(dir string) (blobIndex, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is rebuilt on every run, so it does not need to survive crashes
+	path := filepath.Join(dir, fmt.Sprintf("blobs-%d.db", os.Getpid()))
+	db, err := bolt.Open(path, 0600, &bolt.Options{NoSync: true, NoFreelistSync: true})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBlobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &boltBlobIndex{
+		db:                db,
+		path:              path,
+		pendingBlobs:      make(map[digest]*blobData),
+		pendingReferences: make(map[digest]int64),
+	}, nil
+}
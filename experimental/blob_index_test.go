@@ -0,0 +1,97 @@
+package experimental
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func testDigest(n int) digest {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(n))
+	return digest{hash: sha256.Sum256(data[:])}
+}
+
+func testBlobIndexes(t *testing.T) map[string]blobIndex {
+	bolt, err := newBoltBlobIndex(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]blobIndex{
+		"memory": &memoryBlobIndex{blobs: make(map[digest]*blobData)},
+		"bolt":   bolt,
+	}
+}
+
+func TestBlobIndex(t *testing.T) {
+	// more than a batch, so that some changes are flushed and some are pending
+	const count = boltBlobIndexBatch + boltBlobIndexBatch/2
+
+	for name, index := range testBlobIndexes(t) {
+		t.Run(name, func(t *testing.T) {
+			defer index.close()
+
+			for n := 0; n < count; n++ {
+				err := index.add(&blobData{name: testDigest(n), size: int64(n), etag: "etag"})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// every even blob is referenced twice, concurrently
+			var wg sync.WaitGroup
+			for worker := 0; worker < 2; worker++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for n := 0; n < count; n += 2 {
+						found, err := index.mark(testDigest(n))
+						if err != nil || !found {
+							t.Error("mark:", n, found, err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			found, err := index.mark(testDigest(count))
+			if err != nil || found {
+				t.Fatal("expected missing blob not to be marked:", found, err)
+			}
+
+			tests := []struct {
+				n          int
+				references int64
+			}{
+				{0, 2},
+				{1, 0},
+				{boltBlobIndexBatch, 2},
+				{count - 1, 0},
+			}
+			for _, test := range tests {
+				blob, err := index.get(testDigest(test.n))
+				if err != nil || blob == nil {
+					t.Fatal("get:", test.n, blob, err)
+				}
+				if blob.size != int64(test.n) || blob.etag != "etag" || blob.references != test.references {
+					t.Fatalf("blob %d: unexpected %+v", test.n, blob)
+				}
+			}
+
+			blobs, references := 0, int64(0)
+			err = index.each(func(blob *blobData) error {
+				blobs++
+				references += blob.references
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if blobs != count || references != count {
+				t.Fatalf("expected %d blobs and %d references, got %d and %d", count, count, blobs, references)
+			}
+		})
+	}
+}
@@ -4,64 +4,84 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/dustin/go-humanize"
 )
 
-type blobsData map[digest]*blobData
+type blobsData struct {
+	index blobIndex
+}
+
+func newBlobsData() (blobsData, error) {
+	index, err := newBlobIndex()
+	if err != nil {
+		return blobsData{}, err
+	}
 
-var blobsLock sync.Mutex
+	atExit(func() {
+		err := index.close()
+		if err != nil {
+			logrus.Errorln("BLOB INDEX:", err)
+		}
+	})
+	return blobsData{index: index}, nil
+}
 
 func (b blobsData) mark(digest digest) error {
 	if *ignoreBlobs {
 		return nil
 	}
 
-	blobsLock.Lock()
-	defer blobsLock.Unlock()
-
-	blob := b[digest]
-	if blob == nil {
+	found, err := b.index.mark(digest)
+	if err != nil {
+		return err
+	} else if !found {
 		return fmt.Errorf("blob not found: %v", digest)
 	}
-	blob.references++
 	return nil
 }
 
 func (b blobsData) etag(digest digest) string {
-	blob := b[digest]
-	if blob != nil {
+	blob, err := b.index.get(digest)
+	if err == nil && blob != nil {
 		return blob.etag
 	}
 	return ""
 }
 
 func (b blobsData) size(digest digest) int64 {
-	blob := b[digest]
-	if blob != nil {
+	blob, err := b.index.get(digest)
+	if err == nil && blob != nil {
 		return blob.size
 	}
 	return 0
 }
 
 func (b blobsData) sweep() error {
-	jg := jobsRunner.group()
+	jg := deletesRunner.group()
 
-	for _, blob_ := range b {
-		blob := blob_
-		jg.dispatch(func() error {
-			if blob.references > 0 {
-				return nil
-			}
+	err := b.index.each(func(blob *blobData) error {
+		if blob.references > 0 {
+			return nil
+		}
+
+		if blob.recent {
+			logrus.Infoln("BLOB:", blob.path(), ": is within grace period, skipping")
+			return nil
+		}
 
-			err := deleteFile(blob.path(), blob.size)
+		jg.dispatch(func() error {
+			err := deleteFile(blob.path(), blob.size, "unreferenced blob")
 			if err != nil {
 				return err
 			}
 			return nil
 		})
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return jg.finish()
@@ -85,21 +105,18 @@ func (b blobsData) addBlob(segments []string, info fileInfo) error {
 		return fmt.Errorf("path needs to start with sha256: %v", segments)
 	}
 
-	blobsLock.Lock()
-	defer blobsLock.Unlock()
-
 	blob := &blobData{
-		name: digest,
-		size: info.size,
-		etag: info.etag,
+		name:   digest,
+		size:   info.size,
+		etag:   info.etag,
+		recent: isRecent(info),
 	}
-	b[digest] = blob
-	return nil
+	return b.index.add(blob)
 }
 
 func (b blobsData) walkPath(walkPath string) error {
 	logrus.Infoln("BLOBS DIR:", walkPath)
-	return currentStorage.Walk(walkPath, "blobs", func(path string, info fileInfo, err error) error {
+	return checkpointWalk(walkPath, "blobs", func(path string, info fileInfo, err error) error {
 		err = b.addBlob(strings.Split(path, "/"), info)
 		if err != nil {
 			logrus.Errorln("BLOB:", path, ":", err)
@@ -132,7 +149,7 @@ func (b blobsData) info() {
 		return
 	}
 
-	for _, blob := range b {
+	err := b.index.each(func(blob *blobData) error {
 		if blob.references > 0 {
 			blobsUsed++
 			blobsUsedSize += blob.size
@@ -140,6 +157,10 @@ func (b blobsData) info() {
 			blobsUnused++
 			blobsUnusedSize += blob.size
 		}
+		return nil
+	})
+	if err != nil {
+		logErrorln(err)
 	}
 
 	logrus.Infoln("BLOBS INFO:",
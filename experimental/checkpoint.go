@@ -0,0 +1,233 @@
+package experimental
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var (
+	stateDir = flag.String("state-dir", "", "Directory in which results of finished walks are checkpointed")
+	resume   = flag.Bool("resume", false, "Resume interrupted run from -state-dir, walks that finished are not repeated")
+)
+
+var (
+	checkpointedWalks int32
+	resumedWalks      int32
+)
+
+// checkpointState describes run which walks are checkpointed
+type checkpointState struct {
+	Config    string    `json:"config"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type checkpointEntry struct {
+	Path         string    `json:"path"`
+	FullPath     string    `json:"fullPath,omitempty"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+func checkpointStatePath() string {
+	return filepath.Join(*stateDir, "state.json")
+}
+
+func checkpointWalksPath() string {
+	return filepath.Join(*stateDir, "walks")
+}
+
+func checkpointPath(path, baseDir string) string {
+	hash := sha256.Sum256([]byte(baseDir + "\x00" + path))
+	return filepath.Join(checkpointWalksPath(), hex.EncodeToString(hash[:])+".jsonl")
+}
+
+func loadCheckpointState() (*checkpointState, error) {
+	data, err := ioutil.ReadFile(checkpointStatePath())
+	if err != nil {
+		return nil, err
+	}
+
+	state := &checkpointState{}
+	err = json.Unmarshal(data, state)
+	return state, err
+}
+
+// prepareCheckpoints starts new checkpoints, or continues the ones of interrupted run
+func prepareCheckpoints(configPath string) error {
+	if *stateDir == "" {
+		if *resume {
+			return errors.New("-resume requires -state-dir")
+		}
+		return nil
+	}
+
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+
+	if *resume {
+		state, err := loadCheckpointState()
+		if os.IsNotExist(err) {
+			logrus.Warningln("RESUME:", *stateDir, ": no interrupted run found, starting from the beginning")
+		} else if err != nil {
+			return err
+		} else if state.Config != configPath {
+			return fmt.Errorf("%s was checkpointed for %s, not %s", *stateDir, state.Config, configPath)
+		} else {
+			// Grace period is counted from the start of interrupted run, as its walks are reused
+			runStartedAt = state.StartedAt
+			logrus.Infoln("RESUME: run started at", runStartedAt)
+			return nil
+		}
+	}
+
+	err = os.RemoveAll(checkpointWalksPath())
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(checkpointWalksPath(), 0700)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&checkpointState{Config: configPath, StartedAt: runStartedAt})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(checkpointStatePath(), data)
+}
+
+// clearCheckpoints removes checkpoints, when walks are no longer valid to be resumed
+func clearCheckpoints() error {
+	if *stateDir == "" {
+		return nil
+	}
+
+	err := os.Remove(checkpointStatePath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(checkpointWalksPath())
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	err := ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// replayCheckpoint walks entries of finished walk, returns false if walk was not checkpointed
+func replayCheckpoint(checkpointFile string, fn walkFunc) (bool, error) {
+	file, err := os.Open(checkpointFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		entry := &checkpointEntry{}
+		err := decoder.Decode(entry)
+		if err != nil {
+			return true, err
+		}
+
+		info := fileInfo{
+			fullPath:     entry.FullPath,
+			size:         entry.Size,
+			etag:         entry.ETag,
+			lastModified: entry.LastModified,
+		}
+
+		err = fn(entry.Path, info, nil)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	atomic.AddInt32(&resumedWalks, 1)
+	return true, nil
+}
+
+// checkpointWalk walks storage and saves walked entries,
+// walk is saved only when finished, so interrupted walk is repeated
+func checkpointWalk(path, baseDir string, fn walkFunc) error {
+	if *stateDir == "" {
+		return currentStorage.Walk(path, baseDir, fn)
+	}
+
+	checkpointFile := checkpointPath(path, baseDir)
+
+	if *resume {
+		replayed, err := replayCheckpoint(checkpointFile, fn)
+		if replayed || err != nil {
+			logrus.Infoln("RESUME:", path, ": walk restored from checkpoint")
+			return err
+		}
+	}
+
+	tmpFile := checkpointFile + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+
+	buffer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buffer)
+
+	err = currentStorage.Walk(path, baseDir, func(entryPath string, info fileInfo, err error) error {
+		if err == nil {
+			err := encoder.Encode(&checkpointEntry{
+				Path:         entryPath,
+				FullPath:     info.fullPath,
+				Size:         info.size,
+				ETag:         info.etag,
+				LastModified: info.lastModified,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return fn(entryPath, info, err)
+	})
+
+	if err == nil {
+		err = buffer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	atomic.AddInt32(&checkpointedWalks, 1)
+	return os.Rename(tmpFile, checkpointFile)
+}
+
+func checkpointsInfo() {
+	logrus.Infoln("CHECKPOINT INFO:", checkpointedWalks, "walks checkpointed,", resumedWalks, "walks resumed")
+}
@@ -0,0 +1,107 @@
+package experimental
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// interrupted walk of first run fails
+		interrupted bool
+		// cleared checkpoints after first run, like sweep does
+		cleared bool
+		resume  bool
+		config  string
+		// run continues interrupted one, so grace period is counted from its start
+		continued bool
+		// resumed walk is replayed from checkpoint, so it does not see new blob
+		replayed bool
+		fails    bool
+	}{
+		{name: "resume", resume: true, config: "config.yml", continued: true, replayed: true},
+		{name: "without resume", config: "config.yml"},
+		{name: "resume interrupted walk", interrupted: true, resume: true, config: "config.yml", continued: true},
+		{name: "resume finished run", cleared: true, resume: true, config: "config.yml"},
+		{name: "resume with other config", resume: true, config: "other.yml", fails: true},
+	}
+
+	errInterrupted := errors.New("interrupted")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+			image := newTestImage(r)
+			setTestFlag(t, stateDir, t.TempDir())
+			setTestFlag(t, resume, false)
+
+			walk := func(interrupted bool) ([]string, error) {
+				var paths []string
+				err := checkpointWalk("blobs", "blobs", func(path string, info fileInfo, err error) error {
+					if interrupted {
+						return errInterrupted
+					}
+					paths = append(paths, "blobs/"+path)
+					return err
+				})
+				return sortedPaths(paths...), err
+			}
+
+			err := prepareCheckpoints("config.yml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			startedAt := runStartedAt
+
+			walked, err := walk(test.interrupted)
+			if test.interrupted && err != errInterrupted {
+				t.Fatal("expected walk to be interrupted, got:", err)
+			} else if !test.interrupted && err != nil {
+				t.Fatal(err)
+			}
+
+			if test.cleared {
+				err = clearCheckpoints()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// next run starts later and sees blob pushed in the meantime
+			pushed := r.blob("pushed later")
+			runStartedAt = startedAt.Add(time.Hour)
+			*resume = test.resume
+
+			err = prepareCheckpoints(test.config)
+			if test.fails {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			resumed, err := walk(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.replayed {
+				assertPaths(t, "replayed paths", walked, resumed)
+			} else if !containsPath(resumed, testBlobPath(pushed)) || !containsPath(resumed, testBlobPath(image.orphan)) {
+				t.Fatal("expected blobs to be walked again, got:", resumed)
+			}
+
+			expectedStart := startedAt.Add(time.Hour)
+			if test.continued {
+				expectedStart = startedAt
+			}
+			if !runStartedAt.Equal(expectedStart) {
+				t.Fatalf("expected run started at %v, got %v", expectedStart, runStartedAt)
+			}
+		})
+	}
+}
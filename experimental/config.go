@@ -1,38 +1,97 @@
 package experimental
 
 import (
+	"bytes"
 	"errors"
+	"flag"
+	"fmt"
 	"io/ioutil"
 
+	"github.com/docker/distribution/configuration"
 	"gopkg.in/yaml.v2"
 )
 
+var genericStorageDriver = flag.Bool("generic-storage-driver", false, "Use docker/distribution storage driver, instead of optimised filesystem, S3, GCS, Azure or in-memory storage")
+
 type distributionStorageFilesystem struct {
 	RootDirectory string `yaml:"rootdirectory"`
 }
 
 type distributionStorageS3 struct {
-	AccessKey      string  `yaml:"accesskey"`
-	SecretKey      string  `yaml:"secretkey"`
-	Bucket         string  `yaml:"bucket"`
-	DisableSSL     *bool   `yaml:"disablessl,omitempty"`
-	ForcePathStyle *bool   `yaml:"forcepathstyle,omitempty"`
-	Region         *string `yaml:"region"`
-	RegionEndpoint *string `yaml:"regionendpoint"`
-	RootDirectory  string  `yaml:"rootdirectory"`
+	AccessKey                   string  `yaml:"accesskey"`
+	SecretKey                   string  `yaml:"secretkey"`
+	SessionToken                string  `yaml:"sessiontoken"`
+	CredentialsEndpoint         string  `yaml:"credentialsendpoint"`
+	Bucket                      string  `yaml:"bucket"`
+	DisableSSL                  *bool   `yaml:"disablessl,omitempty"`
+	Secure                      *bool   `yaml:"secure,omitempty"`
+	SkipVerify                  bool    `yaml:"skipverify"`
+	V4Auth                      *bool   `yaml:"v4auth,omitempty"`
+	ForcePathStyle              *bool   `yaml:"forcepathstyle,omitempty"`
+	Accelerate                  bool    `yaml:"accelerate"`
+	Region                      *string `yaml:"region"`
+	RegionEndpoint              *string `yaml:"regionendpoint"`
+	Encrypt                     bool    `yaml:"encrypt"`
+	KeyID                       string  `yaml:"keyid"`
+	StorageClass                string  `yaml:"storageclass"`
+	ObjectACL                   string  `yaml:"objectacl"`
+	UserAgent                   string  `yaml:"useragent"`
+	MultipartCopyChunkSize      int64   `yaml:"multipartcopychunksize"`
+	MultipartCopyMaxConcurrency int     `yaml:"multipartcopymaxconcurrency"`
+	MultipartCopyThresholdSize  int64   `yaml:"multipartcopythresholdsize"`
+	RootDirectory               string  `yaml:"rootdirectory"`
+}
+
+type distributionStorageGCS struct {
+	Bucket        string                 `yaml:"bucket"`
+	KeyFile       string                 `yaml:"keyfile"`
+	Credentials   map[string]interface{} `yaml:"credentials"`
+	RootDirectory string                 `yaml:"rootdirectory"`
+}
+
+type distributionStorageAzure struct {
+	AccountName   string `yaml:"accountname"`
+	AccountKey    string `yaml:"accountkey"`
+	Container     string `yaml:"container"`
+	Realm         string `yaml:"realm"`
+	ServiceURL    string `yaml:"serviceurl"`
+	RootDirectory string `yaml:"rootdirectory"`
+}
+
+type distributionStorageReadOnly struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+type distributionStorageMaintenance struct {
+	ReadOnly *distributionStorageReadOnly `yaml:"readonly"`
 }
 
 type distributionStorage struct {
-	Filesystem *distributionStorageFilesystem `yaml:"filesystem"`
-	S3         *distributionStorageS3         `yaml:"s3"`
+	Filesystem  *distributionStorageFilesystem  `yaml:"filesystem"`
+	S3          *distributionStorageS3          `yaml:"s3"`
+	GCS         *distributionStorageGCS         `yaml:"gcs"`
+	Azure       *distributionStorageAzure       `yaml:"azure"`
+	Maintenance *distributionStorageMaintenance `yaml:"maintenance"`
 }
 
 type distributionConfig struct {
 	Version string              `yaml:"version"`
 	Storage distributionStorage `yaml:"storage"`
+
+	storageSections map[string]interface{}
+	storageDriver   string
+}
+
+type distributionConfigSections struct {
+	Storage map[string]interface{} `yaml:"storage"`
+}
+
+func (c *distributionConfig) readOnly() bool {
+	maintenance := c.Storage.Maintenance
+	return maintenance != nil && maintenance.ReadOnly != nil && maintenance.ReadOnly.Enabled
 }
 
-func storageFromConfig(configFile string) (storageObject, error) {
+func loadConfig(configFile string) (*distributionConfig, error) {
 	data, err := ioutil.ReadFile(configFile)
 	if err != nil {
 		return nil, err
@@ -48,15 +107,48 @@ func storageFromConfig(configFile string) (storageObject, error) {
 		return nil, errors.New("only 0.1 version is supported")
 	}
 
-	if config.Storage.Filesystem != nil && config.Storage.S3 != nil {
-		return nil, errors.New("multiple storages defined")
+	sections := &distributionConfigSections{}
+	err = yaml.Unmarshal(data, sections)
+	if err != nil {
+		return nil, err
+	}
+	config.storageSections = sections.Storage
+
+	// Registry parser knows which storage sections configure the driver
+	registryConfig, err := configuration.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	config.storageDriver = registryConfig.Storage.Type()
+
+	return config, nil
+}
+
+func storageFromConfig(config *distributionConfig) (storageObject, error) {
+	driver := config.storageDriver
+	if driver == "" {
+		return nil, errors.New("unsupported storage")
+	}
+
+	if *genericStorageDriver {
+		// Drivers of this registry version need SDKs that conflict with ones of GCS and Azure storages
+		if driver == "gcs" || driver == "azure" {
+			return nil, fmt.Errorf("%s storage driver is not included, use %s storage without -generic-storage-driver", driver, driver)
+		}
+		return newDriverStorage(driver, config.storageSections[driver])
 	}
 
 	if config.Storage.Filesystem != nil {
 		return newFilesystemStorage(config.Storage.Filesystem)
 	} else if config.Storage.S3 != nil {
 		return newS3Storage(config.Storage.S3)
+	} else if config.Storage.GCS != nil {
+		return newGCSStorage(config.Storage.GCS)
+	} else if config.Storage.Azure != nil {
+		return newAzureStorage(config.Storage.Azure)
+	} else if driver == "inmemory" {
+		return newMemoryStorage()
 	} else {
-		return nil, errors.New("unsupported storage")
+		return newDriverStorage(driver, config.storageSections[driver])
 	}
 }
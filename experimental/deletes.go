@@ -1,24 +1,34 @@
 package experimental
 
 import (
+	"fmt"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/dustin/go-humanize"
 )
 
+const (
+	softDeleteModeMove = "move"
+	softDeleteModeTag  = "tag"
+)
+
 var (
 	deletedLinks    int32
 	deletedBlobs    int32
+	deletedUploads  int32
 	deletedOther    int32
+	deletedVersions int32
 	deletedBlobSize int64
 )
 
-func deleteFile(path string, size int64) error {
-	logrus.Infoln("DELETE", path, size)
+func countDelete(path string, size int64) {
 	name := filepath.Base(path)
-	if name == "link" {
+	if strings.Contains(path, "/_uploads/") {
+		atomic.AddInt32(&deletedUploads, 1)
+	} else if name == "link" {
 		atomic.AddInt32(&deletedLinks, 1)
 	} else if name == "data" {
 		atomic.AddInt32(&deletedBlobs, 1)
@@ -27,23 +37,88 @@ func deleteFile(path string, size int64) error {
 	}
 
 	atomic.AddInt64(&deletedBlobSize, size)
+}
+
+func countVersionDelete(size int64) {
+	atomic.AddInt32(&deletedVersions, 1)
+	atomic.AddInt64(&deletedBlobSize, size)
+}
+
+// isPlanned reports whether entry can be deleted when deletion plan is applied
+func isPlanned(entry *planEntry) bool {
+	if currentPlan != nil && !currentPlan.allows(entry) {
+		logrus.Infoln("SKIP", entry.Path, entry.ID, entry.Size, ": not in deletion plan")
+		return false
+	}
+	return true
+}
+
+// deletePlanned runs remove, when data is deleted, and records entry in deletion plan,
+// entries of data that failed to be deleted are not recorded
+func deletePlanned(entry *planEntry, remove func() error) error {
+	// Do not delete, only write
+	if *delete {
+		err := remove()
+		if err != nil {
+			return err
+		}
+	}
+
+	if currentPlanWriter != nil {
+		return currentPlanWriter.write(entry)
+	}
+	return nil
+}
 
-	if !*delete {
-		// Do not delete, only write
+func deleteFile(path string, size int64, reason string) error {
+	entry := newPlanEntry(path, size, reason)
+	if !isPlanned(entry) {
 		return nil
 	}
 
-	if *softDelete {
-		return currentStorage.Move(path, filepath.Join("backup", path))
-	} else {
-		return currentStorage.Delete(path)
+	logrus.Infoln("DELETE", path, size)
+
+	err := deletePlanned(entry, func() error {
+		if *softDelete && *softDeleteMode == softDeleteModeTag {
+			return currentStorage.(expiringStorage).Expire(path)
+		} else if *softDelete {
+			return currentStorage.Move(path, filepath.Join("backup", path))
+		} else {
+			return currentStorage.Delete(path)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	countDelete(path, size)
+	return nil
+}
+
+func checkSoftDeleteMode() error {
+	switch *softDeleteMode {
+	case softDeleteModeMove:
+		return nil
+
+	case softDeleteModeTag:
+		if _, ok := currentStorage.(expiringStorage); !ok {
+			return fmt.Errorf("soft-delete-mode %q is supported only on S3 storage", *softDeleteMode)
+		} else if *s3PurgeVersions {
+			return fmt.Errorf("soft-delete-mode %q can not be used with -s3-purge-versions, it removes expired versions", *softDeleteMode)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown soft-delete-mode: %q", *softDeleteMode)
 	}
 }
 
 func deletesInfo() {
 	logrus.Warningln("DELETEABLE INFO:", deletedLinks, "links,",
 		deletedBlobs, "blobs,",
+		deletedUploads, "uploads,",
 		deletedOther, "other,",
+		deletedVersions, "versions,",
 		humanize.Bytes(uint64(deletedBlobSize)),
 	)
 }
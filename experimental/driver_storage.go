@@ -0,0 +1,273 @@
+package experimental
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+
+	_ "github.com/docker/distribution/registry/storage/driver/filesystem"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+	_ "github.com/docker/distribution/registry/storage/driver/s3-aws"
+	_ "github.com/docker/distribution/registry/storage/driver/swift"
+)
+
+// driverStorage uses any of docker/distribution storage drivers,
+// it is slow as every file needs to be stat-ed, but supports all storages that registry supports
+type driverStorage struct {
+	driver storagedriver.StorageDriver
+	*driverStats
+	backup bool
+}
+
+type driverStats struct {
+	storageCache
+	apiCalls  int64
+	statCalls int64
+}
+
+func (f *driverStorage) fullPath(path string) string {
+	if f.backup {
+		return f.backupPath(path)
+	}
+	return f.livePath(path)
+}
+
+func (f *driverStorage) livePath(path string) string {
+	return filepath.Join("/", "docker", "registry", "v2", path)
+}
+
+// backupPath is relative to the root directory of storage driver
+func (f *driverStorage) backupPath(path string) string {
+	return filepath.Join(backupRoot("/"), "docker-backup", "registry", "v2", path)
+}
+
+func (f *driverStorage) stat(path string) (fileInfo, error) {
+	atomic.AddInt64(&f.statCalls, 1)
+	info, err := f.driver.Stat(context.Background(), path)
+	if err != nil {
+		return fileInfo{}, err
+	}
+
+	return fileInfo{
+		fullPath:     info.Path(),
+		size:         info.Size(),
+		etag:         blobEtag(info.Path()),
+		lastModified: info.ModTime(),
+		directory:    info.IsDir(),
+	}, nil
+}
+
+// blobEtag returns digest reference of blob data, drivers do not provide etags,
+// but blob data is addressed by digest of its content
+func blobEtag(path string) string {
+	if filepath.Base(path) != "data" || !strings.Contains(path, "/blobs/") {
+		return ""
+	}
+
+	if digestHex := pathDigest(path); digestHex != "" {
+		return digestReferenceAlgorithm + digestHex
+	}
+	return ""
+}
+
+func (f *driverStorage) list(path string, fn func(fullPath string, info fileInfo) error) error {
+	atomic.AddInt64(&f.apiCalls, 1)
+	children, err := f.driver.List(context.Background(), path)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		info, err := f.stat(child)
+		if err != nil {
+			return err
+		}
+
+		err = fn(child, info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *driverStorage) walk(path string, baseDir string, fn walkFunc) error {
+	return f.list(path, func(fullPath string, info fileInfo) error {
+		if info.directory {
+			return f.walk(fullPath, baseDir, fn)
+		}
+
+		return fn(strings.TrimPrefix(fullPath, baseDir), info, nil)
+	})
+}
+
+func (f *driverStorage) Walk(path string, baseDir string, fn walkFunc) error {
+	return f.walk(f.fullPath(path), f.fullPath(baseDir)+"/", fn)
+}
+
+func (f *driverStorage) List(path string, fn walkFunc) error {
+	path = f.fullPath(path)
+
+	return f.list(path, func(fullPath string, info fileInfo) error {
+		return fn(strings.TrimPrefix(fullPath, path+"/"), info, nil)
+	})
+}
+
+func (f *driverStorage) Read(path string, etag string) ([]byte, error) {
+	if data := f.readCache(path, etag); data != nil {
+		return data, nil
+	}
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	data, err := f.driver.GetContent(context.Background(), f.fullPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	f.writeCache(path, etag, data)
+	return data, nil
+}
+
+func (f *driverStorage) Delete(path string) error {
+	atomic.AddInt64(&f.apiCalls, 1)
+	return f.driver.Delete(context.Background(), f.fullPath(path))
+}
+
+func (f *driverStorage) RemoveEmptyDirectory(path string) error {
+	empty := true
+	err := f.Walk(path, path, func(path string, info fileInfo, err error) error {
+		empty = false
+		return err
+	})
+	if err != nil || !empty {
+		return err
+	}
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	err = f.driver.Delete(context.Background(), f.fullPath(path))
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+func (f *driverStorage) Move(path, newPath string) error {
+	// Moving out of backup restores data
+	if f.backup {
+		newPath = f.livePath(newPath)
+	} else {
+		newPath = f.backupPath(newPath)
+	}
+
+	movedAt := time.Now().Truncate(time.Second)
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	err := f.driver.Move(context.Background(), f.fullPath(path), newPath)
+	if err != nil || f.backup {
+		return err
+	}
+
+	return f.stampBackup(newPath, movedAt)
+}
+
+// stampBackup rewrites backup that kept modification time of moved data,
+// as backups expire from the time of deletion
+func (f *driverStorage) stampBackup(path string, movedAt time.Time) error {
+	info, err := f.stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.lastModified.Before(movedAt) {
+		return nil
+	}
+
+	tmpPath := path + ".tmp"
+	err = f.copy(path, tmpPath)
+	if err != nil {
+		f.driver.Delete(context.Background(), tmpPath)
+		return err
+	}
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	return f.driver.Move(context.Background(), tmpPath, path)
+}
+
+func (f *driverStorage) copy(path, newPath string) error {
+	atomic.AddInt64(&f.apiCalls, 2)
+
+	reader, err := f.driver.Reader(context.Background(), path, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := f.driver.Writer(context.Background(), newPath, false)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		writer.Cancel()
+		return err
+	}
+	return writer.Commit()
+}
+
+func (f *driverStorage) Backup() storageObject {
+	backup := *f
+	backup.backup = true
+	return &backup
+}
+
+func (f *driverStorage) Info() {
+	logrus.Infoln("DRIVER INFO:", f.driver.Name(), "API calls/stat:", f.apiCalls, f.statCalls,
+		"Cache (hit/miss/error):", f.cacheHits, f.cacheMiss, f.cacheError)
+}
+
+// driverParameters converts YAML maps to form that is expected by storage drivers
+func driverParameters(value interface{}) (map[string]interface{}, error) {
+	parameters := make(map[string]interface{})
+
+	switch value := value.(type) {
+	case nil:
+	case map[interface{}]interface{}:
+		for key, value := range value {
+			parameters[fmt.Sprint(key)] = value
+		}
+	default:
+		return nil, fmt.Errorf("invalid storage driver parameters: %v", value)
+	}
+
+	return parameters, nil
+}
+
+func newDriverStorage(name string, config interface{}) (storageObject, error) {
+	parameters, err := driverParameters(config)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := factory.Create(name, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &driverStorage{
+		driver:      driver,
+		driverStats: &driverStats{},
+	}
+	return storage, nil
+}
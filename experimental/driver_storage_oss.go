@@ -0,0 +1,7 @@
+//go:build include_oss
+// +build include_oss
+
+package experimental
+
+// The driver is built by distribution only with include_oss tag
+import _ "github.com/docker/distribution/registry/storage/driver/oss"
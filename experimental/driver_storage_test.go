@@ -0,0 +1,151 @@
+package experimental
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDriverStorage(t *testing.T, name string, config interface{}) *driverStorage {
+	t.Helper()
+
+	storage, err := newDriverStorage(name, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage.(*driverStorage)
+}
+
+func TestDriverStorage(t *testing.T) {
+	storage := newTestDriverStorage(t, "inmemory", nil)
+
+	testStorage(t, storage, func(path string, data []byte) error {
+		return storage.driver.PutContent(context.Background(), storage.livePath(path), data)
+	})
+}
+
+func TestDriverStorageReadCache(t *testing.T) {
+	setTestFlag(t, storageCacheDir, t.TempDir())
+
+	storage := newTestDriverStorage(t, "inmemory", nil)
+
+	data := []byte("manifest")
+	hash := sha256.Sum256(data)
+	blob := "blobs/sha256/" + hex.EncodeToString(hash[:1]) + "/" + hex.EncodeToString(hash[:]) + "/data"
+
+	err := storage.driver.PutContent(context.Background(), storage.livePath(blob), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, ok := testWalk(t, storage, "blobs")[blob[len("blobs/"):]]
+	if !ok {
+		t.Fatal("not walked:", blob)
+	} else if info.etag != digestReferenceAlgorithm+hex.EncodeToString(hash[:]) {
+		t.Fatal("unexpected etag:", info.etag)
+	}
+
+	for i := 0; i < 2; i++ {
+		read, err := storage.Read(blob, info.etag)
+		if err != nil || string(read) != string(data) {
+			t.Fatalf("expected %q, got %q %v", data, read, err)
+		}
+	}
+
+	if storage.cacheMiss != 1 || storage.cacheHits != 1 {
+		t.Fatalf("expected 1 cache miss and 1 hit, got %d and %d", storage.cacheMiss, storage.cacheHits)
+	}
+
+	// cached data that does not match digest is read again
+	err = os.WriteFile(storage.cachePath(blob), []byte("corrupted"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := storage.Read(blob, info.etag)
+	if err != nil || string(read) != string(data) || storage.cacheError != 1 {
+		t.Fatalf("expected %q read again, got %q %v", data, read, err)
+	}
+}
+
+func TestDriverStorageBackupTime(t *testing.T) {
+	root := t.TempDir()
+	storage := newTestDriverStorage(t, "filesystem", map[interface{}]interface{}{"rootdirectory": root})
+
+	path := "repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link"
+	data := "sha256:579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1"
+
+	err := storage.driver.PutContent(context.Background(), storage.livePath(path), []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushedAt := time.Now().Add(-30 * 24 * time.Hour)
+	err = os.Chtimes(filepath.Join(root, storage.livePath(path)), pushedAt, pushedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deletedAt := time.Now().Truncate(time.Second)
+	err = storage.Move(path, "backup/"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := storage.Backup()
+	files := testWalk(t, backup, "backup")
+	if len(files) != 1 {
+		t.Fatal("expected only moved data in backup, got:", files)
+	}
+
+	info, ok := files[path]
+	if !ok {
+		t.Fatal("expected data to be moved to backup:", files)
+	} else if info.lastModified.Before(deletedAt) {
+		t.Fatal("expected backup to be modified when deleted, got:", info.lastModified)
+	}
+
+	read, err := backup.Read("backup/"+path, "")
+	if err != nil || string(read) != data {
+		t.Fatalf("expected %q, got %q %v", data, read, err)
+	}
+}
+
+func TestDriverStorageRemoveEmptyDirectory(t *testing.T) {
+	storage := newTestDriverStorage(t, "filesystem", map[interface{}]interface{}{"rootdirectory": t.TempDir()})
+
+	tags := "repositories/group/app/_manifests/tags"
+	link := tags + "/stable/current/link"
+	err := storage.driver.PutContent(context.Background(), storage.livePath(link), []byte("sha256:579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1"))
+	if err == nil {
+		err = storage.driver.PutContent(context.Background(), storage.livePath(tags+"/latest/current/link"), nil)
+	}
+	if err == nil {
+		err = storage.Delete(tags + "/latest/current/link")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{tags + "/latest", tags + "/stable", tags + "/missing"} {
+		err := storage.RemoveEmptyDirectory(path)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+	}
+
+	var listed []string
+	err = storage.List(tags, func(path string, info fileInfo, err error) error {
+		listed = append(listed, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(listed) != 1 || listed[0] != "stable" {
+		t.Fatal("expected only directory with data to be kept, got:", listed)
+	}
+}
@@ -1,22 +1,34 @@
 package experimental
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type fsStorage struct {
 	*distributionStorageFilesystem
+	backup bool
 }
 
 func (f *fsStorage) fullPath(path string) string {
+	if f.backup {
+		return f.backupPath(path)
+	}
+	return f.livePath(path)
+}
+
+func (f *fsStorage) livePath(path string) string {
 	return filepath.Join(f.RootDirectory, "docker", "registry", "v2", path)
 }
 
 func (f *fsStorage) backupPath(path string) string {
-	return filepath.Join(f.RootDirectory, "docker-backup", "registry", "v2", path)
+	return filepath.Join(backupRoot(f.RootDirectory), "docker-backup", "registry", "v2", path)
 }
 
 func (f *fsStorage) Walk(rootDir string, baseDir string, fn walkFunc) error {
@@ -32,7 +44,15 @@ func (f *fsStorage) Walk(rootDir string, baseDir string, fn walkFunc) error {
 	}
 	baseDir += "/"
 
+	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
+		return nil
+	}
+
 	return filepath.Walk(rootDir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
 		if info.IsDir() {
 			return nil
 		}
@@ -47,7 +67,7 @@ func (f *fsStorage) Walk(rootDir string, baseDir string, fn walkFunc) error {
 			return nil
 		}
 
-		fi := fileInfo{fullPath: fullPath, size: info.Size()}
+		fi := fileInfo{fullPath: fullPath, size: info.Size(), lastModified: info.ModTime()}
 		return fn(path, fi, err)
 	})
 }
@@ -70,7 +90,7 @@ func (f *fsStorage) List(rootDir string, fn walkFunc) error {
 			return nil
 		}
 
-		fi := fileInfo{fullPath: fullPath, size: info.Size(), directory: info.IsDir()}
+		fi := fileInfo{fullPath: fullPath, size: info.Size(), lastModified: info.ModTime(), directory: info.IsDir()}
 
 		if info.IsDir() {
 			err = fn(path, fi, err)
@@ -81,7 +101,7 @@ func (f *fsStorage) List(rootDir string, fn walkFunc) error {
 			return filepath.SkipDir
 		} else {
 
-			fi := fileInfo{fullPath: fullPath, size: info.Size()}
+			fi := fileInfo{fullPath: fullPath, size: info.Size(), lastModified: info.ModTime()}
 			return fn(path, fi, err)
 		}
 	})
@@ -95,16 +115,159 @@ func (f *fsStorage) Delete(path string) error {
 	return os.Remove(f.fullPath(path))
 }
 
+func (f *fsStorage) RemoveEmptyDirectory(path string) error {
+	var directories []string
+
+	err := filepath.Walk(f.fullPath(path), func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			directories = append(directories, fullPath)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Remove nested directories first, directories with data are kept
+	for i := len(directories) - 1; i >= 0; i-- {
+		err := os.Remove(directories[i])
+		if err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EEXIST) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *fsStorage) Move(path, newPath string) error {
+	return f.move(path, newPath, renameFile)
+}
+
+func (f *fsStorage) MoveExclusive(path, newPath string) error {
+	return f.move(path, newPath, linkFile)
+}
+
+func (f *fsStorage) move(path, newPath string, rename func(string, string) error) error {
 	path = f.fullPath(path)
-	newPath = f.backupPath(newPath)
+
+	// Moving out of backup restores data
+	if f.backup {
+		newPath = f.livePath(newPath)
+	} else {
+		newPath = f.backupPath(newPath)
+	}
+
 	os.MkdirAll(filepath.Dir(newPath), 0700)
-	return os.Rename(path, newPath)
+	err := moveFile(path, newPath, rename)
+	if err != nil {
+		return err
+	}
+
+	if !f.backup {
+		// Rename keeps modification time, but backups expire from the time of deletion
+		now := time.Now()
+		return os.Chtimes(newPath, now, now)
+	}
+	return nil
+}
+
+// renameFile is replaced by tests to move data across devices
+var renameFile = os.Rename
+
+// linkFile renames file, but fails when newPath exists, instead of replacing it
+func linkFile(path, newPath string) error {
+	err := os.Link(path, newPath)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// moveFile renames file, or copies and removes it when backup is on another device
+func moveFile(path, newPath string, rename func(string, string) error) error {
+	err := rename(path, newPath)
+	if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// Copy to temporary file first, to never leave partial data under the new path
+	tmpPath := newPath + ".tmp"
+	err = copyFile(path, tmpPath, info.Mode())
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = rename(tmpPath, newPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = syncDir(filepath.Dir(newPath))
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func copyFile(path, newPath string, mode os.FileMode) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		return err
+	}
+
+	err = destination.Sync()
+	if err != nil {
+		return err
+	}
+	return destination.Close()
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+func (f *fsStorage) Backup() storageObject {
+	return &fsStorage{distributionStorageFilesystem: f.distributionStorageFilesystem, backup: true}
 }
 
 func (f *fsStorage) Info() {
 }
 
 func newFilesystemStorage(config *distributionStorageFilesystem) (storageObject, error) {
-	return &fsStorage{config}, nil
+	return &fsStorage{distributionStorageFilesystem: config}, nil
 }
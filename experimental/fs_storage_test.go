@@ -0,0 +1,176 @@
+package experimental
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFilesystemStorage(t *testing.T) {
+	storage, err := newFilesystemStorage(&distributionStorageFilesystem{RootDirectory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, storage, func(path string, data []byte) error {
+		fullPath := storage.(*fsStorage).livePath(path)
+		err := os.MkdirAll(filepath.Dir(fullPath), 0700)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(fullPath, data, 0600)
+	})
+}
+
+// setTestCrossDeviceRename makes renames of path fail as if newPath was on another device
+func setTestCrossDeviceRename(t *testing.T, path string) {
+	setTestFlag(t, &renameFile, func(oldPath, newPath string) error {
+		if oldPath == path {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EXDEV}
+		}
+		return os.Rename(oldPath, newPath)
+	})
+}
+
+func TestMoveFileAcrossDevices(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "registry", "data")
+	newPath := filepath.Join(dir, "backup", "data")
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(newPath), 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(path, []byte("layer"), 0640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modifiedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = os.Chtimes(path, modifiedAt, modifiedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestCrossDeviceRename(t, path)
+
+	t.Run("copy fails", func(t *testing.T) {
+		err := moveFile(path, filepath.Join(dir, "missing", "data"), renameFile)
+		if err == nil {
+			t.Fatal("expected copy to missing directory to fail")
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal("expected data to be kept when copy fails:", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "missing", "data.tmp")); !os.IsNotExist(err) {
+			t.Fatal("expected no temporary data to be left:", err)
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		err := moveFile(path, newPath, renameFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatal("expected data to be removed after it is copied:", err)
+		}
+		if _, err := os.Stat(newPath + ".tmp"); !os.IsNotExist(err) {
+			t.Fatal("expected temporary data to be renamed:", err)
+		}
+
+		data, err := os.ReadFile(newPath)
+		if err != nil || string(data) != "layer" {
+			t.Fatalf("expected copied data, got %q %v", data, err)
+		}
+
+		info, err := os.Stat(newPath)
+		if err != nil {
+			t.Fatal(err)
+		} else if info.Mode().Perm() != 0640 || !info.ModTime().Equal(modifiedAt) {
+			t.Fatalf("expected mode and modification time to be kept, got %v %v", info.Mode(), info.ModTime())
+		}
+	})
+}
+
+func TestFilesystemStorageMoveAcrossDevices(t *testing.T) {
+	root := t.TempDir()
+	setTestFlag(t, backupRootDirectory, t.TempDir())
+
+	storage := &fsStorage{distributionStorageFilesystem: &distributionStorageFilesystem{RootDirectory: root}}
+	path := "repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link"
+
+	err := os.MkdirAll(filepath.Dir(storage.livePath(path)), 0700)
+	if err == nil {
+		err = os.WriteFile(storage.livePath(path), []byte("sha256:579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushedAt := time.Now().Add(-30 * 24 * time.Hour)
+	err = os.Chtimes(storage.livePath(path), pushedAt, pushedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestCrossDeviceRename(t, storage.livePath(path))
+
+	deletedAt := time.Now().Truncate(time.Second)
+	err = storage.Move(path, "backup/"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, ok := testWalk(t, storage.Backup(), "backup")[path]
+	if !ok {
+		t.Fatal("expected data to be moved to backup directory:", *backupRootDirectory)
+	} else if info.lastModified.Before(deletedAt) {
+		t.Fatal("expected backup to expire from the time of deletion, got:", info.lastModified)
+	}
+}
+
+func TestMoveFileExclusive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	newPath := filepath.Join(dir, "pushed")
+
+	err := os.WriteFile(path, []byte("restored"), 0600)
+	if err == nil {
+		err = os.WriteFile(newPath, []byte("pushed again"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = moveFile(path, newPath, linkFile)
+	if !os.IsExist(err) {
+		t.Fatal("expected move to refuse replacing existing data, got:", err)
+	}
+
+	for path, expected := range map[string]string{path: "restored", newPath: "pushed again"} {
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != expected {
+			t.Fatalf("expected %s to be kept as %q, got %q %v", path, expected, data, err)
+		}
+	}
+
+	err = os.Remove(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = moveFile(path, newPath, linkFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected data to be moved:", err)
+	}
+}
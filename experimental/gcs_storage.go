@@ -0,0 +1,245 @@
+package experimental
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+	"github.com/Sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type gcsStorage struct {
+	*distributionStorageGCS
+	*gcsStats
+	client *storage.Client
+	bucket *storage.BucketHandle
+	backup bool
+}
+
+type gcsStats struct {
+	storageCache
+	apiCalls          int64
+	expensiveApiCalls int64
+	freeApiCalls      int64
+}
+
+func (f *gcsStorage) fullPath(path string) string {
+	if f.backup {
+		return f.backupPath(path)
+	}
+	return f.livePath(path)
+}
+
+func (f *gcsStorage) livePath(path string) string {
+	return strings.TrimPrefix(filepath.Join(f.RootDirectory, "docker", "registry", "v2", path), "/")
+}
+
+func (f *gcsStorage) backupPath(path string) string {
+	return strings.TrimPrefix(filepath.Join(backupRoot(f.RootDirectory), "docker-backup", "registry", "v2", path), "/")
+}
+
+func gcsEtag(attrs *storage.ObjectAttrs) string {
+	// composite objects do not have md5
+	if len(attrs.MD5) == 0 {
+		return ""
+	}
+	return "\"" + hex.EncodeToString(attrs.MD5) + "\""
+}
+
+func (f *gcsStorage) list(query *storage.Query, fn func(attrs *storage.ObjectAttrs) error) error {
+	it := f.bucket.Objects(context.Background(), query)
+	pager := iterator.NewPager(it, listMax, "")
+
+	for {
+		var page []*storage.ObjectAttrs
+
+		atomic.AddInt64(&f.apiCalls, 1)
+		nextPageToken, err := pager.NextPage(&page)
+		if err != nil {
+			return err
+		}
+
+		for _, attrs := range page {
+			err = fn(attrs)
+			if err != nil {
+				return err
+			}
+		}
+
+		if nextPageToken == "" {
+			return nil
+		}
+	}
+}
+
+func (f *gcsStorage) Walk(path string, baseDir string, fn walkFunc) error {
+	path = f.fullPath(path) + "/"
+	baseDir = f.fullPath(baseDir) + "/"
+
+	return f.list(&storage.Query{Prefix: path}, func(attrs *storage.ObjectAttrs) error {
+		keyPath := attrs.Name
+		if strings.HasPrefix(keyPath, baseDir) {
+			keyPath = keyPath[len(baseDir):]
+		}
+
+		if keyPath == "" {
+			return nil
+		}
+
+		if strings.HasSuffix(keyPath, "/") {
+			logrus.Debugln("GCS Walk:", keyPath, "for", baseDir)
+			return nil
+		}
+
+		fi := fileInfo{
+			fullPath:     attrs.Name,
+			size:         attrs.Size,
+			etag:         gcsEtag(attrs),
+			lastModified: attrs.Updated,
+		}
+		return fn(keyPath, fi, nil)
+	})
+}
+
+func (f *gcsStorage) List(path string, fn walkFunc) error {
+	path = f.fullPath(path) + "/"
+
+	return f.list(&storage.Query{Prefix: path, Delimiter: "/"}, func(attrs *storage.ObjectAttrs) error {
+		if attrs.Prefix != "" {
+			prefixPath := strings.TrimPrefix(attrs.Prefix, path)
+			if prefixPath == "" {
+				return nil
+			}
+
+			fi := fileInfo{
+				fullPath:  attrs.Prefix,
+				directory: true,
+			}
+			return fn(prefixPath, fi, nil)
+		}
+
+		keyPath := strings.TrimPrefix(attrs.Name, path)
+		if keyPath == "" {
+			return nil
+		}
+
+		fi := fileInfo{
+			fullPath:     attrs.Name,
+			size:         attrs.Size,
+			etag:         gcsEtag(attrs),
+			lastModified: attrs.Updated,
+			directory:    strings.HasSuffix(attrs.Name, "/"),
+		}
+		return fn(keyPath, fi, nil)
+	})
+}
+
+func (f *gcsStorage) Read(path string, etag string) ([]byte, error) {
+	if data := f.readCache(path, etag); data != nil {
+		return data, nil
+	}
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	reader, err := f.bucket.Object(f.fullPath(path)).NewReader(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	f.writeCache(path, etag, data)
+	return data, nil
+}
+
+func (f *gcsStorage) Delete(path string) error {
+	atomic.AddInt64(&f.freeApiCalls, 1)
+	return f.bucket.Object(f.fullPath(path)).Delete(context.Background())
+}
+
+func (f *gcsStorage) Move(path, newPath string) error {
+	return f.move(path, newPath, false)
+}
+
+func (f *gcsStorage) MoveExclusive(path, newPath string) error {
+	return f.move(path, newPath, true)
+}
+
+func (f *gcsStorage) move(path, newPath string, exclusive bool) error {
+	// Moving out of backup restores data
+	if f.backup {
+		newPath = f.livePath(newPath)
+	} else {
+		newPath = f.backupPath(newPath)
+	}
+
+	destination := f.bucket.Object(newPath)
+	if exclusive {
+		destination = destination.If(storage.Conditions{DoesNotExist: true})
+	}
+
+	atomic.AddInt64(&f.expensiveApiCalls, 1)
+	source := f.bucket.Object(f.fullPath(path))
+	_, err := destination.CopierFrom(source).Run(context.Background())
+
+	var apiErr *googleapi.Error
+	if exclusive && errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return &os.PathError{Op: "move", Path: newPath, Err: os.ErrExist}
+	} else if err != nil {
+		return err
+	}
+	return f.Delete(path)
+}
+
+func (f *gcsStorage) Backup() storageObject {
+	backup := *f
+	backup.backup = true
+	return &backup
+}
+
+func (f *gcsStorage) Info() {
+	logrus.Infoln("GCS INFO: API calls/expensive/free:", f.apiCalls, f.expensiveApiCalls, f.freeApiCalls,
+		"Cache (hit/miss/error):", f.cacheHits, f.cacheMiss, f.cacheError)
+}
+
+func newGCSStorage(config *distributionStorageGCS) (storageObject, error) {
+	var options []option.ClientOption
+
+	if config.KeyFile != "" {
+		options = append(options, option.WithCredentialsFile(config.KeyFile))
+	} else if config.Credentials != nil {
+		credentials, err := json.Marshal(config.Credentials)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option.WithCredentialsJSON(credentials))
+	}
+
+	// STORAGE_EMULATOR_HOST is respected by client, what allows to use fake GCS server
+	client, err := storage.NewClient(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &gcsStorage{
+		distributionStorageGCS: config,
+		gcsStats:               &gcsStats{},
+		client:                 client,
+		bucket:                 client.Bucket(config.Bucket),
+	}
+	return storage, nil
+}
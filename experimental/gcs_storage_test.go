@@ -0,0 +1,103 @@
+package experimental
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// TestGCSStorage runs against fake-gcs-server, started with:
+// fake-gcs-server -scheme http -port 4443 -public-host 127.0.0.1:4443 and STORAGE_EMULATOR_HOST=127.0.0.1:4443
+func TestGCSStorage(t *testing.T) {
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("STORAGE_EMULATOR_HOST is not set")
+	}
+
+	storage, err := newGCSStorage(&distributionStorageGCS{
+		Bucket:        "registry",
+		RootDirectory: fmt.Sprintf("test-%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gcs := storage.(*gcsStorage)
+	err = gcs.bucket.Create(context.Background(), "test", nil)
+
+	var apiErr *googleapi.Error
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict) {
+		t.Fatal(err)
+	}
+
+	// fake-gcs-server ignores preconditions of copies, restore checks live data itself,
+	// preconditions are tested with TestGCSStorageMoveExclusive
+	testStorage(t, testStorageWithoutPreconditions{storage}, func(path string, data []byte) error {
+		writer := gcs.bucket.Object(gcs.livePath(path)).NewWriter(context.Background())
+		_, err := writer.Write(data)
+		if err != nil {
+			writer.Close()
+			return err
+		}
+		return writer.Close()
+	})
+}
+
+// testStorageWithoutPreconditions hides exclusive moves of storage
+type testStorageWithoutPreconditions struct {
+	storageObject
+}
+
+func (s testStorageWithoutPreconditions) Backup() storageObject {
+	return testStorageWithoutPreconditions{s.storageObject.Backup()}
+}
+
+func TestGCSStorageMoveExclusive(t *testing.T) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/rewriteTo/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		queries = append(queries, r.URL.Query())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		fmt.Fprint(w, `{"error":{"code":412,"message":"At least one of the pre-conditions you specified did not hold."}}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	storage, err := newGCSStorage(&distributionStorageGCS{Bucket: "registry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link"
+	err = storage.Backup().(exclusiveStorage).MoveExclusive("backup/"+path, path)
+	if !os.IsExist(err) {
+		t.Fatal("expected failed precondition to be reported as existing data, got:", err)
+	}
+
+	err = storage.Move(path, "backup/"+path)
+	if err == nil || os.IsExist(err) {
+		t.Fatal("expected move to fail without precondition, got:", err)
+	}
+
+	if len(queries) != 2 {
+		t.Fatal("expected two copies, got:", queries)
+	} else if queries[0].Get("ifGenerationMatch") != "0" {
+		t.Fatal("expected exclusive copy to require destination not to exist, got:", queries[0])
+	} else if queries[1].Has("ifGenerationMatch") {
+		t.Fatal("expected move without precondition, got:", queries[1])
+	}
+}
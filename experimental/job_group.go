@@ -15,6 +15,8 @@ func (g *jobGroup) dispatch(fn func() error) {
 	g.ch <- func() {
 		var err error
 
+		// error is recorded before job is done, so finish sees it
+		defer g.wg.Done()
 		defer func() {
 			if err != nil {
 				g.lock.Lock()
@@ -22,7 +24,6 @@ func (g *jobGroup) dispatch(fn func() error) {
 				g.lock.Unlock()
 			}
 		}()
-		defer g.wg.Done()
 
 		err = fn()
 	}
@@ -0,0 +1,76 @@
+package experimental
+
+import (
+	"sync"
+)
+
+// linkIndex keeps links of repository and how many times they are used
+type linkIndex interface {
+	set(link digest, used int64) error
+	// use adds use to link, creating it when missing, returns false if link did not exist
+	use(link digest) (bool, error)
+	// mark adds use to link, returns false if link does not exist
+	mark(link digest) (bool, error)
+	used(link digest) (int64, error)
+	each(fn func(link digest, used int64) error) error
+}
+
+type memoryLinkIndex struct {
+	links map[digest]int64
+	lock  sync.Mutex
+}
+
+func (i *memoryLinkIndex) set(link digest, used int64) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.links[link] = used
+	return nil
+}
+
+func (i *memoryLinkIndex) use(link digest) (bool, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	_, ok := i.links[link]
+	i.links[link]++
+	return ok, nil
+}
+
+func (i *memoryLinkIndex) mark(link digest) (bool, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	_, ok := i.links[link]
+	if ok {
+		i.links[link]++
+	}
+	return ok, nil
+}
+
+func (i *memoryLinkIndex) used(link digest) (int64, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.links[link], nil
+}
+
+func (i *memoryLinkIndex) each(fn func(link digest, used int64) error) error {
+	for link, used := range i.links {
+		err := fn(link, used)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repositoryLinks keeps links of all repositories on disk, when -blob-index-dir is used
+var repositoryLinks *boltLinkStore
+
+func newLinkIndex(repository, kind string) linkIndex {
+	if repositoryLinks != nil {
+		return repositoryLinks.index(repository, kind)
+	}
+	return &memoryLinkIndex{links: make(map[digest]int64)}
+}
@@ -0,0 +1,310 @@
+package experimental
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltLinksBucket = []byte("links")
+
+// boltLinkStore keeps links of all repositories in a temporary bolt database,
+// keyed by repository, kind of link and its digest
+type boltLinkStore struct {
+	db   *bolt.DB
+	path string
+
+	// lock is held for reading by lookups and for writing by flush,
+	// so that lookups see every change either pending or committed
+	lock sync.RWMutex
+
+	pendingLock  sync.Mutex
+	pendingLinks map[string]int64
+	pendingUses  map[string]int64
+}
+
+type boltLinkIndex struct {
+	store  *boltLinkStore
+	prefix string
+}
+
+func encodeLinkUsed(used int64) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(used))
+	return value
+}
+
+func decodeLinkUsed(key, value []byte) (int64, error) {
+	if len(value) != 8 {
+		return 0, fmt.Errorf("corrupted link index entry: %q", key)
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
+
+// flush writes pending changes, lock has to be held for writing
+func (s *boltLinkStore) flush() error {
+	if len(s.pendingLinks) == 0 && len(s.pendingUses) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltLinksBucket)
+
+		for key, used := range s.pendingLinks {
+			err := bucket.Put([]byte(key), encodeLinkUsed(used))
+			if err != nil {
+				return err
+			}
+		}
+
+		for key, uses := range s.pendingUses {
+			value := bucket.Get([]byte(key))
+			if value == nil {
+				continue
+			}
+
+			used, err := decodeLinkUsed([]byte(key), value)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(key), encodeLinkUsed(used+uses))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	s.pendingLinks = make(map[string]int64)
+	s.pendingUses = make(map[string]int64)
+	return err
+}
+
+func (s *boltLinkStore) flushAll() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.flush()
+}
+
+func (s *boltLinkStore) flushBatch() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// other caller could flush the batch already
+	if len(s.pendingLinks) < boltBlobIndexBatch && len(s.pendingUses) < boltBlobIndexBatch {
+		return nil
+	}
+	return s.flush()
+}
+
+// lookup returns used count of link, lock has to be held
+func (s *boltLinkStore) lookup(key string) (int64, bool, error) {
+	s.pendingLock.Lock()
+	used, pending := s.pendingLinks[key]
+	uses := s.pendingUses[key]
+	s.pendingLock.Unlock()
+
+	if pending {
+		return used + uses, true, nil
+	}
+
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value = tx.Bucket(boltLinksBucket).Get([]byte(key))
+		if value != nil {
+			value = append([]byte(nil), value...)
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		return 0, false, err
+	}
+
+	used, err = decodeLinkUsed([]byte(key), value)
+	return used + uses, true, err
+}
+
+func (s *boltLinkStore) set(key string, used int64) error {
+	s.lock.RLock()
+	s.pendingLock.Lock()
+	s.pendingLinks[key] = used
+	full := len(s.pendingLinks) >= boltBlobIndexBatch
+	s.pendingLock.Unlock()
+	s.lock.RUnlock()
+
+	if !full {
+		return nil
+	}
+	return s.flushBatch()
+}
+
+func (s *boltLinkStore) addUse(key string, create bool) (bool, error) {
+	s.lock.RLock()
+
+	_, found, err := s.lookup(key)
+	if err != nil || !found && !create {
+		s.lock.RUnlock()
+		return false, err
+	}
+
+	s.pendingLock.Lock()
+	if !found {
+		if _, ok := s.pendingLinks[key]; !ok {
+			s.pendingLinks[key] = 0
+		}
+	}
+	s.pendingUses[key]++
+	full := len(s.pendingLinks) >= boltBlobIndexBatch || len(s.pendingUses) >= boltBlobIndexBatch
+	s.pendingLock.Unlock()
+	s.lock.RUnlock()
+
+	if !full {
+		return found, nil
+	}
+	return found, s.flushBatch()
+}
+
+func (s *boltLinkStore) each(prefix string, fn func(link digest, used int64) error) error {
+	err := s.flushAll()
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		link digest
+		used int64
+	}
+
+	// Links are read in batches, so that fn can change the index
+	after := []byte(prefix)
+	for {
+		var entries []entry
+
+		s.lock.RLock()
+		err = s.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(boltLinksBucket).Cursor()
+
+			key, value := cursor.Seek(after)
+			if bytes.Equal(key, after) {
+				key, value = cursor.Next()
+			}
+
+			for ; key != nil && bytes.HasPrefix(key, []byte(prefix)); key, value = cursor.Next() {
+				used, err := decodeLinkUsed(key, value)
+				if err != nil {
+					return err
+				}
+
+				var link digest
+				if copy(link.hash[:], key[len(prefix):]) != len(link.hash) {
+					return fmt.Errorf("corrupted link index entry: %q", key)
+				}
+
+				s.pendingLock.Lock()
+				used += s.pendingUses[string(key)]
+				s.pendingLock.Unlock()
+
+				entries = append(entries, entry{link: link, used: used})
+				if len(entries) >= boltBlobIndexBatch {
+					after = append([]byte(nil), key...)
+					break
+				}
+			}
+			return nil
+		})
+		s.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			err = fn(entry.link, entry.used)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(entries) < boltBlobIndexBatch {
+			return nil
+		}
+	}
+}
+
+func (s *boltLinkStore) index(repository, kind string) linkIndex {
+	return &boltLinkIndex{
+		store:  s,
+		prefix: repository + "\x00" + kind + "\x00",
+	}
+}
+
+func (s *boltLinkStore) close() error {
+	err := s.db.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (i *boltLinkIndex) key(link digest) string {
+	return i.prefix + string(link.hash[:])
+}
+
+func (i *boltLinkIndex) set(link digest, used int64) error {
+	return i.store.set(i.key(link), used)
+}
+
+func (i *boltLinkIndex) use(link digest) (bool, error) {
+	return i.store.addUse(i.key(link), true)
+}
+
+func (i *boltLinkIndex) mark(link digest) (bool, error) {
+	return i.store.addUse(i.key(link), false)
+}
+
+func (i *boltLinkIndex) used(link digest) (int64, error) {
+	i.store.lock.RLock()
+	defer i.store.lock.RUnlock()
+
+	used, _, err := i.store.lookup(i.key(link))
+	return used, err
+}
+
+func (i *boltLinkIndex) each(fn func(link digest, used int64) error) error {
+	return i.store.each(i.prefix, fn)
+}
+
+func newBoltLinkStore(dir string) (*boltLinkStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is rebuilt on every run, so it does not need to survive crashes
+	path := filepath.Join(dir, fmt.Sprintf("links-%d.db", os.Getpid()))
+	db, err := bolt.Open(path, 0600, &bolt.Options{NoSync: true, NoFreelistSync: true})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltLinksBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &boltLinkStore{
+		db:           db,
+		path:         path,
+		pendingLinks: make(map[string]int64),
+		pendingUses:  make(map[string]int64),
+	}, nil
+}
@@ -0,0 +1,113 @@
+package experimental
+
+import (
+	"sync"
+	"testing"
+)
+
+func testLinkIndexes(t *testing.T) map[string]func(repository, kind string) linkIndex {
+	store, err := newBoltLinkStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.close() })
+
+	return map[string]func(repository, kind string) linkIndex{
+		"memory": func(repository, kind string) linkIndex {
+			return &memoryLinkIndex{links: make(map[digest]int64)}
+		},
+		"bolt": store.index,
+	}
+}
+
+func TestLinkIndex(t *testing.T) {
+	const count = boltBlobIndexBatch + boltBlobIndexBatch/2
+
+	for name, newIndex := range testLinkIndexes(t) {
+		t.Run(name, func(t *testing.T) {
+			layers := newIndex("group/project", "layers")
+			manifests := newIndex("group/project", "manifests")
+			// repository with name prefixed by the other one, must not share links
+			other := newIndex("group/project/sub", "layers")
+
+			var wg sync.WaitGroup
+			for worker := 0; worker < 4; worker++ {
+				wg.Add(1)
+				go func(worker int) {
+					defer wg.Done()
+					for n := worker; n < count; n += 4 {
+						err := layers.set(testDigest(n), int64(n%2))
+						if err != nil {
+							t.Error(err)
+						}
+					}
+				}(worker)
+			}
+			wg.Wait()
+
+			err := other.set(testDigest(0), 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for n := 0; n < count; n += 2 {
+				found, err := layers.mark(testDigest(n))
+				if err != nil || !found {
+					t.Fatal("mark:", n, found, err)
+				}
+			}
+
+			tests := []struct {
+				name  string
+				call  func() (bool, error)
+				found bool
+			}{
+				{"mark missing", func() (bool, error) { return layers.mark(testDigest(count)) }, false},
+				{"use missing", func() (bool, error) { return manifests.use(testDigest(0)) }, false},
+				{"use created", func() (bool, error) { return manifests.use(testDigest(0)) }, true},
+				{"mark created", func() (bool, error) { return manifests.mark(testDigest(0)) }, true},
+			}
+			for _, test := range tests {
+				found, err := test.call()
+				if err != nil || found != test.found {
+					t.Fatalf("%s: expected %v, got %v %v", test.name, test.found, found, err)
+				}
+			}
+
+			used := []struct {
+				index linkIndex
+				n     int
+				used  int64
+			}{
+				{layers, 0, 1},
+				{layers, 1, 1},
+				{layers, count, 0},
+				{manifests, 0, 3},
+				{other, 0, 5},
+			}
+			for _, test := range used {
+				value, err := test.index.used(testDigest(test.n))
+				if err != nil || value != test.used {
+					t.Fatalf("link %d: expected %d uses, got %d %v", test.n, test.used, value, err)
+				}
+			}
+
+			links, unused := 0, 0
+			err = layers.each(func(link digest, used int64) error {
+				links++
+				if used == 0 {
+					unused++
+				}
+				// changes while iterating are allowed
+				_, err := layers.mark(link)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if links != count || unused != 0 {
+				t.Fatalf("expected %d links all used, got %d with %d unused", count, links, unused)
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package experimental
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
 func analyzeLink(args []string) (digest, error) {
@@ -47,6 +49,12 @@ func analyzeLinkSignature(args []string) (digest, digest, error) {
 }
 
 func compareEtag(data []byte, etag string) bool {
+	// blob data read through storage driver is validated with its digest
+	if strings.HasPrefix(etag, digestReferenceAlgorithm) {
+		hash := sha256.Sum256(data)
+		return etag == digestReferenceAlgorithm+hex.EncodeToString(hash[:])
+	}
+
 	hash := md5.Sum(data)
 	hex := hex.EncodeToString(hash[:])
 	hex = "\"" + hex + "\""
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -16,6 +17,7 @@ var (
 
 	jobs             = flag.Int("jobs", 10, "Number of concurrent jobs to execute")
 	parallelWalkJobs = flag.Int("parallel-walk-jobs", 10, "Number of concurrent parallel walk jobs to execute")
+	deleteJobs       = flag.Int("delete-jobs", 100, "Number of concurrent delete jobs to execute")
 
 	debug      = flag.Bool("debug", false, "Print debug messages")
 	verbose    = flag.Bool("verbose", true, "Print verbose messages")
@@ -27,24 +29,66 @@ var (
 	repositoryCsvOutput = flag.String("repository-csv-output", "repositories.csv", "File to which CSV will be written with all metrics")
 
 	deleteOldTagVersions = flag.Bool("delete-old-tag-versions", true, "Delete old tag versions")
+	tagPolicyFile        = flag.String("tag-policy", "", "Path to tag retention policy file")
 	delete               = flag.Bool("delete", false, "Delete data, instead of dry run")
 	softDelete           = flag.Bool("soft-delete", true, "When deleting, do not remove, but move to backup/ folder")
+	softDeleteMode       = flag.String("soft-delete-mode", softDeleteModeMove, "How data is soft-deleted: move (copy to backup/ folder) or tag (tag in place to be removed by S3 lifecycle rule)")
+	gracePeriod          = flag.Duration("grace-period", 0, "Never delete blobs, links and manifests modified within this duration before start of the run")
+	deleteOldUploads     = flag.Duration("delete-old-uploads", 0, "Delete uploads started longer ago than this duration (0 keeps all uploads)")
 )
 
 var (
 	jobsRunner         = make(jobsData)
 	parallelWalkRunner = make(jobsData)
+	deletesRunner      = make(jobsData)
+	runStartedAt       = time.Now()
 )
 
+var (
+	exitHandlers     []func()
+	exitHandlersLock sync.Mutex
+)
+
+func atExit(fn func()) {
+	exitHandlersLock.Lock()
+	defer exitHandlersLock.Unlock()
+
+	exitHandlers = append(exitHandlers, fn)
+}
+
+func runExitHandlers() {
+	exitHandlersLock.Lock()
+	defer exitHandlersLock.Unlock()
+
+	for idx := len(exitHandlers) - 1; idx >= 0; idx-- {
+		exitHandlers[idx]()
+	}
+	exitHandlers = nil
+}
+
+func fatalln(args ...interface{}) {
+	runExitHandlers()
+	logrus.Fatalln(args...)
+}
+
 func logErrorln(args ...interface{}) {
 	if *softErrors {
 		logrus.Errorln(args...)
 	} else {
-		logrus.Fatalln(args...)
+		fatalln(args...)
 	}
 }
 
+// isRecent reports whether object could be created by push that runs concurrently with us
+func isRecent(info fileInfo) bool {
+	if info.lastModified.IsZero() {
+		return false
+	}
+	return info.lastModified.After(runStartedAt.Add(-*gracePeriod))
+}
+
 func Main() {
+	runStartedAt = time.Now()
 	flag.Parse()
 
 	if *debug {
@@ -62,28 +106,115 @@ func Main() {
 		os.Exit(1)
 	}
 
-	var err error
-	currentStorage, err = storageFromConfig(*config)
+	registryConfig, err := loadConfig(*config)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	currentStorage, err = storageFromConfig(registryConfig)
 	if err != nil {
 		logrus.Fatalln(err)
 	}
+	defer runExitHandlers()
+
+	err = checkSoftDeleteMode()
+	if err != nil {
+		fatalln(err)
+	}
+
+	if *applyPlan != "" {
+		currentPlan, err = loadDeletionPlan(*applyPlan)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+	}
 
-	blobs := make(blobsData)
-	repositories := make(repositoriesData)
+	if *planOutput != "" {
+		currentPlanWriter, err = newPlanWriter(*planOutput)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+	}
+
+	if *manifestCacheFile != "" {
+		currentManifestCache, err = loadManifestCache(*manifestCacheFile)
+		if err != nil {
+			fatalln(err)
+		}
+	}
+
+	var policies *tagPolicies
+	if *tagPolicyFile != "" {
+		policies, err = loadTagPolicies(*tagPolicyFile)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+	}
+
+	blobs, err := newBlobsData()
+	if err != nil {
+		fatalln(err)
+	}
+
+	repositories, err := newRepositoriesData()
+	if err != nil {
+		fatalln(err)
+	}
 
 	jobsRunner.run(*jobs)
 	parallelWalkRunner.run(*parallelWalkJobs)
+	deletesRunner.run(*deleteJobs)
 
-	signals := make(chan os.Signal)
+	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, os.Kill)
 
 	go func() {
 		for signal := range signals {
 			currentStorage.Info()
-			logrus.Fatalln("Signal received:", signal)
+			if *stateDir != "" {
+				fatalln("Signal received:", signal, ": finished walks are checkpointed in", *stateDir+", run again with -resume to continue")
+			}
+			fatalln("Signal received:", signal, ": use -state-dir to checkpoint walks and -resume to continue them")
 		}
 	}()
 
+	err = prepareMaintenance(registryConfig)
+	if err != nil {
+		fatalln(err)
+	}
+
+	if *restore {
+		err = restoreBackup()
+		if err != nil {
+			logErrorln(err)
+		}
+
+		restoreInfo()
+		currentStorage.Info()
+		return
+	}
+
+	if *purgeBackupsOlderThan > 0 {
+		err = purgeBackups(*purgeBackupsOlderThan)
+		if err != nil {
+			logErrorln(err)
+		}
+
+		err = purgeStorageVersions(currentStorage.Backup())
+		if err != nil {
+			logErrorln(err)
+		}
+
+		deletesInfo()
+		currentStorage.Info()
+		return
+	}
+
+	err = prepareCheckpoints(*config)
+	if err != nil {
+		fatalln(err)
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -111,8 +242,22 @@ func Main() {
 
 	wg.Wait()
 
+	if policies != nil {
+		logrus.Infoln("Applying TAG POLICIES...")
+		repositories.applyTagPolicies(policies)
+	}
+
 	logrus.Infoln("Marking REPOSITORIES...")
 	err = repositories.mark(blobs)
+	if err != nil {
+		logErrorln(err)
+	} else if currentManifestCache != nil {
+		// Every used manifest was loaded by marking
+		currentManifestCache.dropUnseen()
+	}
+
+	// Sweeping changes data, so walks can no longer be resumed
+	err = clearCheckpoints()
 	if err != nil {
 		logErrorln(err)
 	}
@@ -123,15 +268,60 @@ func Main() {
 		logErrorln(err)
 	}
 
+	if *deleteOldUploads > 0 {
+		logrus.Infoln("Sweeping UPLOADS...")
+		err = repositories.sweepUploads(*deleteOldUploads)
+		if err != nil {
+			logErrorln(err)
+		}
+	}
+
+	if *deleteOldMultipartUploads > 0 {
+		logrus.Infoln("Sweeping MULTIPART UPLOADS...")
+		err = sweepMultipartUploads(*deleteOldMultipartUploads)
+		if err != nil {
+			logErrorln(err)
+		}
+	}
+
 	logrus.Infoln("Sweeping BLOBS...")
 	err = blobs.sweep()
 	if err != nil {
 		logErrorln(err)
 	}
 
+	err = purgeStorageVersions(currentStorage)
+	if err != nil {
+		logErrorln(err)
+	}
+
 	logrus.Infoln("Summary...")
 	repositories.info(blobs, *repositoryCsvOutput)
 	blobs.info()
 	deletesInfo()
+
+	if *stateDir != "" {
+		checkpointsInfo()
+	}
+
+	if currentManifestCache != nil {
+		currentManifestCache.info()
+	}
+
+	if *deleteOldMultipartUploads > 0 {
+		multipartUploadsInfo()
+	}
+
 	currentStorage.Info()
+
+	if currentPlan != nil {
+		currentPlan.info()
+	}
+
+	if currentPlanWriter != nil {
+		err = currentPlanWriter.close()
+		if err != nil {
+			logErrorln(err)
+		}
+	}
 }
@@ -0,0 +1,59 @@
+package experimental
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsRecent(t *testing.T) {
+	startedAt := time.Now()
+	setTestFlag(t, &runStartedAt, startedAt)
+
+	tests := []struct {
+		name         string
+		gracePeriod  time.Duration
+		lastModified time.Time
+		recent       bool
+	}{
+		{"unknown modification time", time.Hour, time.Time{}, false},
+		{"before grace period", time.Hour, startedAt.Add(-time.Hour - time.Second), false},
+		{"at start of grace period", time.Hour, startedAt.Add(-time.Hour), false},
+		{"within grace period", time.Hour, startedAt.Add(-time.Hour + time.Second), true},
+		{"during run", time.Hour, startedAt.Add(time.Second), true},
+		{"at start of run without grace period", 0, startedAt, false},
+		{"during run without grace period", 0, startedAt.Add(time.Second), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestFlag(t, gracePeriod, test.gracePeriod)
+
+			if recent := isRecent(fileInfo{lastModified: test.lastModified}); recent != test.recent {
+				t.Fatalf("expected recent %v, got %v", test.recent, recent)
+			}
+		})
+	}
+}
+
+func TestGracePeriodKeepsRecentPush(t *testing.T) {
+	for _, grace := range []time.Duration{0, time.Hour} {
+		t.Run(grace.String(), func(t *testing.T) {
+			r := newTestRepository(t)
+			image := r.pushImage(mediaTypeOCIManifest, "image layer")
+			concurrent := r.pushImage(mediaTypeOCIManifest, "concurrently pushed layer")
+			r.tag(image.manifest)
+			all := r.livePaths()
+
+			setTestFlag(t, delete, true)
+			setTestFlag(t, softDelete, false)
+			setTestFlag(t, gracePeriod, grace)
+			r.prune()
+
+			expected := without(all, unusedPaths(concurrent))
+			if grace > 0 {
+				expected = all
+			}
+			assertPaths(t, "live paths", expected, r.livePaths())
+		})
+	}
+}
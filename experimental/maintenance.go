@@ -0,0 +1,142 @@
+package experimental
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	iKnowRegistryIsLive = flag.Bool("i-know-registry-is-live", false, "Allow to delete data when registry is not in read-only mode")
+	setReadOnly         = flag.Bool("set-read-only", false, "Switch registry config to read-only mode for the time of the run, and restore it afterwards")
+	readOnlyWait        = flag.Duration("read-only-wait", time.Minute, "Time to wait for registry to be restarted in read-only mode, before data is deleted")
+)
+
+// setConfigValue sets value of mapping node under path, keeping comments and order of other keys
+func setConfigValue(mapping *yaml.Node, path []string, tag, value string) {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value != path[0] {
+			continue
+		}
+
+		node := mapping.Content[idx+1]
+		if len(path) == 1 {
+			node.Kind, node.Tag, node.Value, node.Content = yaml.ScalarNode, tag, value, nil
+			return
+		}
+
+		// key without value, as `maintenance:`
+		if node.Kind != yaml.MappingNode {
+			node.Kind, node.Tag, node.Value, node.Content = yaml.MappingNode, "!!map", "", nil
+		}
+		setConfigValue(node, path[1:], tag, value)
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+	if len(path) == 1 {
+		mapping.Content = append(mapping.Content, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value})
+		return
+	}
+
+	child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setConfigValue(child, path[1:], tag, value)
+	mapping.Content = append(mapping.Content, key, child)
+}
+
+// enableReadOnly rewrites registry config to use read-only mode,
+// and returns function that restores original config file
+func enableReadOnly(configFile string) (func() error, error) {
+	stat, err := os.Stat(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	} else if len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("registry config is not a YAML mapping")
+	}
+
+	setConfigValue(document.Content[0], []string{"storage", "maintenance", "readonly", "enabled"}, "!!bool", "true")
+
+	var readOnlyData bytes.Buffer
+	encoder := yaml.NewEncoder(&readOnlyData)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	if err != nil {
+		return nil, err
+	}
+	encoder.Close()
+
+	err = ioutil.WriteFile(configFile, readOnlyData.Bytes(), stat.Mode())
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return ioutil.WriteFile(configFile, data, stat.Mode())
+	}, nil
+}
+
+func prepareMaintenance(registryConfig *distributionConfig) error {
+	if !*delete {
+		return nil
+	}
+
+	if registryConfig.readOnly() {
+		logrus.Infoln("Registry is in read-only mode")
+		return nil
+	}
+
+	// In-memory storage is never shared with running registry
+	if _, ok := currentStorage.(*memoryStorage); ok {
+		return nil
+	}
+
+	if *setReadOnly {
+		if *readOnlyWait <= 0 {
+			return errors.New("-set-read-only requires -read-only-wait: registry has to be restarted in read-only mode before data is deleted")
+		}
+
+		restoreConfig, err := enableReadOnly(*config)
+		if err != nil {
+			return err
+		}
+
+		atExit(func() {
+			err := restoreConfig()
+			if err != nil {
+				logrus.Errorln("Failed to restore registry config:", err)
+			} else {
+				logrus.Warningln("Registry config restored, registry has to be restarted to leave read-only mode")
+			}
+		})
+
+		// Registry reads config only when started, restarting it is up to the operator
+		logrus.Warningln("Registry config switched to read-only mode, waiting", *readOnlyWait,
+			"for registry to be restarted with it...")
+		time.Sleep(*readOnlyWait)
+		return nil
+	}
+
+	if *iKnowRegistryIsLive {
+		logrus.Warningln("Registry is not in read-only mode, deleting data of live registry")
+		return nil
+	}
+
+	return errors.New("registry is not in read-only mode: use -set-read-only or -i-know-registry-is-live")
+}
@@ -0,0 +1,133 @@
+package experimental
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnableReadOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name: "without maintenance",
+			config: `# registry of team
+version: 0.1
+storage:
+  # blobs are kept on local disk
+  filesystem:
+    rootdirectory: /var/lib/registry # mounted volume
+`,
+		},
+		{
+			name: "with maintenance",
+			config: `version: 0.1
+storage:
+  filesystem:
+    rootdirectory: /var/lib/registry
+  maintenance:
+    # uploads are purged by pruner
+    uploadpurging:
+      enabled: false
+    readonly:
+      enabled: false # switched by pruner
+`,
+		},
+		{
+			name: "with empty maintenance",
+			config: `version: 0.1
+storage:
+  filesystem:
+    rootdirectory: /var/lib/registry
+  maintenance:
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.yml")
+			err := os.WriteFile(configFile, []byte(test.config), 0640)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restoreConfig, err := enableReadOnly(configFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := loadConfig(configFile)
+			if err != nil {
+				t.Fatal(err)
+			} else if !config.readOnly() {
+				t.Fatal("expected registry config in read-only mode")
+			} else if config.Storage.Filesystem == nil || config.Storage.Filesystem.RootDirectory != "/var/lib/registry" {
+				t.Fatalf("expected storage config to be kept, got %+v", config.Storage)
+			}
+
+			data, err := os.ReadFile(configFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(test.config, "\n") {
+				if idx := strings.Index(line, "#"); idx >= 0 && !strings.Contains(string(data), line[idx:]) {
+					t.Fatalf("expected comment %q to be kept, got:\n%s", line[idx:], data)
+				}
+			}
+
+			err = restoreConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err = os.ReadFile(configFile)
+			if err != nil || string(data) != test.config {
+				t.Fatalf("expected original config restored, got:\n%s %v", data, err)
+			}
+		})
+	}
+}
+
+func TestPrepareMaintenanceSetReadOnly(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(configFile, []byte("version: 0.1\nstorage:\n  filesystem:\n    rootdirectory: /var/lib/registry\n"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registryConfig, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestFlag(t, config, configFile)
+	setTestFlag(t, delete, true)
+	setTestFlag(t, setReadOnly, true)
+	setTestFlag(t, &currentStorage, storageObject(&fsStorage{}))
+	t.Cleanup(runExitHandlers)
+
+	setTestFlag(t, readOnlyWait, 0)
+	err = prepareMaintenance(registryConfig)
+	if err == nil {
+		t.Fatal("expected -set-read-only to require -read-only-wait")
+	}
+
+	setTestFlag(t, readOnlyWait, 50*time.Millisecond)
+	startedAt := time.Now()
+	err = prepareMaintenance(registryConfig)
+	if err != nil {
+		t.Fatal(err)
+	} else if waited := time.Since(startedAt); waited < *readOnlyWait {
+		t.Fatal("expected to wait for registry to be restarted, waited:", waited)
+	}
+
+	readOnlyConfig, err := loadConfig(configFile)
+	if err != nil || !readOnlyConfig.readOnly() {
+		t.Fatal("expected registry config in read-only mode", err)
+	}
+}
@@ -15,10 +15,12 @@ import (
 )
 
 type manifestData struct {
-	digest  digest
-	layers  []digest
-	loaded  bool
-	loadErr error
+	digest    digest
+	mediaType string
+	layers    []digest
+	manifests []digest
+	loaded    bool
+	loadErr   error
 
 	loadLock sync.Mutex
 }
@@ -35,8 +37,13 @@ func deserializeManifest(data []byte) (distribution.Manifest, error) {
 		err := json.Unmarshal(data, &sm)
 		return sm, err
 	case 2:
-		// This can be an image manifest or a manifest list
-		switch versioned.MediaType {
+		// This can be an image manifest, a manifest list or an OCI index
+		mediaType := versioned.MediaType
+		if mediaType == "" {
+			mediaType = detectOCIMediaType(data)
+		}
+
+		switch mediaType {
 		case schema2.MediaTypeManifest:
 			var m schema2.DeserializedManifest
 			err := json.Unmarshal(data, &m)
@@ -45,19 +52,36 @@ func deserializeManifest(data []byte) (distribution.Manifest, error) {
 			var m manifestlist.DeserializedManifestList
 			err := json.Unmarshal(data, &m)
 			return m, err
+		case mediaTypeOCIManifest:
+			return deserializeOCIManifest(data)
+		case mediaTypeOCIIndex:
+			return deserializeOCIIndex(data)
 		default:
-			return nil, distribution.ErrManifestVerification{fmt.Errorf("unrecognized manifest content type %s", versioned.MediaType)}
+			return nil, distribution.ErrManifestVerification{fmt.Errorf("unrecognized manifest content type %s", mediaType)}
 		}
 	}
 
 	return nil, fmt.Errorf("unrecognized manifest schema version %d", versioned.SchemaVersion)
 }
 
+func isManifestList(m distribution.Manifest) bool {
+	switch m.(type) {
+	case manifestlist.DeserializedManifestList, *ociIndex:
+		return true
+	default:
+		return false
+	}
+}
+
 func (m *manifestData) path() string {
 	return filepath.Join("blobs", m.digest.scopedPath(), "data")
 }
 
 func (m *manifestData) load(blobs blobsData) error {
+	if currentManifestCache != nil && currentManifestCache.get(m) {
+		return nil
+	}
+
 	logrus.Println("MANIFEST:", m.path(), ": loading...")
 
 	data, err := currentStorage.Read(m.path(), blobs.etag(m.digest))
@@ -70,12 +94,26 @@ func (m *manifestData) load(blobs blobsData) error {
 		return err
 	}
 
+	m.mediaType, _, err = manifest.Payload()
+	if err != nil {
+		return err
+	}
+
 	for _, reference := range manifest.References() {
 		digest, err := newDigestFromReference([]byte(reference.Digest))
 		if err != nil {
 			return err
 		}
-		m.layers = append(m.layers, digest)
+
+		if isManifestList(manifest) {
+			m.manifests = append(m.manifests, digest)
+		} else {
+			m.layers = append(m.layers, digest)
+		}
+	}
+
+	if currentManifestCache != nil {
+		currentManifestCache.put(m, data)
 	}
 	return nil
 }
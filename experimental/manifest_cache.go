@@ -0,0 +1,250 @@
+package experimental
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const manifestCacheMaxLine = 64 * 1024 * 1024
+
+var manifestCacheFile = flag.String("manifest-cache", "", "File in which parsed manifests are kept between runs, to not read them again")
+
+// manifestCacheEntry describes parsed manifest,
+// manifests are immutable so entries never need to be invalidated
+type manifestCacheEntry struct {
+	Digest    string   `json:"digest"`
+	MediaType string   `json:"mediaType"`
+	Layers    []string `json:"layers,omitempty"`
+	Manifests []string `json:"manifests,omitempty"`
+	Checksum  string   `json:"checksum"`
+}
+
+type manifestCache struct {
+	entries map[digest]*manifestCacheEntry
+	// seen are manifests read from cache or stored by the run
+	seen    map[digest]bool
+	changed bool
+	lock    sync.Mutex
+
+	hits    int64
+	misses  int64
+	invalid int64
+	dropped int64
+}
+
+var currentManifestCache *manifestCache
+
+func (e *manifestCacheEntry) checksum() string {
+	hash := sha256.New()
+	hash.Write([]byte(e.Digest + "\n" + e.MediaType + "\n"))
+	hash.Write([]byte(strings.Join(e.Layers, ",") + "\n"))
+	hash.Write([]byte(strings.Join(e.Manifests, ",") + "\n"))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func newManifestCacheEntry(m *manifestData) *manifestCacheEntry {
+	entry := &manifestCacheEntry{
+		Digest:    string(m.digest.reference()),
+		MediaType: m.mediaType,
+	}
+	for _, layer := range m.layers {
+		entry.Layers = append(entry.Layers, string(layer.reference()))
+	}
+	for _, manifest := range m.manifests {
+		entry.Manifests = append(entry.Manifests, string(manifest.reference()))
+	}
+	entry.Checksum = entry.checksum()
+	return entry
+}
+
+func parseDigests(references []string) ([]digest, error) {
+	var digests []digest
+	for _, reference := range references {
+		digest, err := newDigestFromReference([]byte(reference))
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// get fills manifest from cache, returns false if manifest is not cached
+func (c *manifestCache) get(m *manifestData) bool {
+	c.lock.Lock()
+	entry := c.entries[m.digest]
+	if entry != nil {
+		c.seen[m.digest] = true
+	}
+	c.lock.Unlock()
+
+	if entry == nil {
+		atomic.AddInt64(&c.misses, 1)
+		return false
+	}
+
+	layers, err := parseDigests(entry.Layers)
+	if err != nil {
+		atomic.AddInt64(&c.invalid, 1)
+		return false
+	}
+
+	manifests, err := parseDigests(entry.Manifests)
+	if err != nil {
+		atomic.AddInt64(&c.invalid, 1)
+		return false
+	}
+
+	m.mediaType = entry.MediaType
+	m.layers = layers
+	m.manifests = manifests
+	atomic.AddInt64(&c.hits, 1)
+	return true
+}
+
+// put stores parsed manifest, data is verified to be the content of the manifest digest
+func (c *manifestCache) put(m *manifestData, data []byte) {
+	if sha256.Sum256(data) != m.digest.hash {
+		logrus.Debugln("MANIFEST CACHE:", m.path(), ": content does not match digest, not cached")
+		return
+	}
+
+	entry := newManifestCacheEntry(m)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[m.digest] = entry
+	c.seen[m.digest] = true
+	c.changed = true
+}
+
+// dropUnseen removes entries of manifests that were not used by the run,
+// so the cache does not grow with manifests deleted from registry
+func (c *manifestCache) dropUnseen() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entries := make(map[digest]*manifestCacheEntry, len(c.seen))
+	for digest, entry := range c.entries {
+		if c.seen[digest] {
+			entries[digest] = entry
+		}
+	}
+
+	if len(entries) < len(c.entries) {
+		c.dropped += int64(len(c.entries) - len(entries))
+		c.changed = true
+	}
+	c.entries = entries
+}
+
+// save atomically replaces cache file with all valid entries
+func (c *manifestCache) save(cacheFile string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.changed {
+		return nil
+	}
+
+	tmpFile := cacheFile + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+
+	buffer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buffer)
+
+	for _, entry := range c.entries {
+		err = encoder.Encode(entry)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = buffer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, cacheFile)
+}
+
+func (c *manifestCache) info() {
+	logrus.Infoln("MANIFEST CACHE INFO: Entries/hits/misses/invalid/dropped:", len(c.entries), c.hits, c.misses, c.invalid, c.dropped)
+}
+
+func (c *manifestCache) load(cacheFile string) error {
+	file, err := os.Open(cacheFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, manifestCacheMaxLine)
+
+	for scanner.Scan() {
+		entry := &manifestCacheEntry{}
+		err := json.Unmarshal(scanner.Bytes(), entry)
+		if err != nil || entry.Checksum != entry.checksum() {
+			c.invalid++
+			continue
+		}
+
+		digest, err := newDigestFromReference([]byte(entry.Digest))
+		if err != nil {
+			c.invalid++
+			continue
+		}
+
+		c.entries[digest] = entry
+	}
+
+	// Invalid entries are dropped on the next save
+	c.changed = c.invalid > 0
+	if c.invalid > 0 {
+		logrus.Warningln("MANIFEST CACHE:", cacheFile, ":", c.invalid, "invalid entries ignored")
+	}
+	return scanner.Err()
+}
+
+func loadManifestCache(cacheFile string) (*manifestCache, error) {
+	cache := &manifestCache{
+		entries: make(map[digest]*manifestCacheEntry),
+		seen:    make(map[digest]bool),
+	}
+
+	err := cache.load(cacheFile)
+	if err != nil {
+		return nil, err
+	}
+
+	atExit(func() {
+		err := cache.save(cacheFile)
+		if err != nil {
+			logrus.Errorln("MANIFEST CACHE:", cacheFile, ":", err)
+		}
+	})
+	return cache, nil
+}
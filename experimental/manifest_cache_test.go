@@ -0,0 +1,196 @@
+package experimental
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestCacheLoad(t *testing.T) {
+	valid := func() *manifestCacheEntry {
+		entry := &manifestCacheEntry{
+			Digest:    "sha256:567887c05cd8349a5434f642c8ad2331648933f8abd6a932394e3ecd88dfaf87",
+			MediaType: mediaTypeOCIManifest,
+			Layers:    []string{"sha256:95cf1a2e1698fe3ca1fcc3f653119146b271d0b62e487ec264441e886a11bd06"},
+		}
+		entry.Checksum = entry.checksum()
+		return entry
+	}
+
+	encode := func(entry *manifestCacheEntry) string {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	tests := []struct {
+		name    string
+		line    func() string
+		entries int
+		invalid int64
+	}{
+		{
+			name:    "valid",
+			line:    func() string { return encode(valid()) },
+			entries: 1,
+		},
+		{
+			name: "changed layers",
+			line: func() string {
+				entry := valid()
+				entry.Layers = nil
+				return encode(entry)
+			},
+			invalid: 1,
+		},
+		{
+			name: "invalid digest",
+			line: func() string {
+				entry := valid()
+				entry.Digest = "sha256:invalid"
+				entry.Checksum = entry.checksum()
+				return encode(entry)
+			},
+			invalid: 1,
+		},
+		{
+			name:    "truncated",
+			line:    func() string { return encode(valid())[0:40] },
+			invalid: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cacheFile := filepath.Join(t.TempDir(), "manifests.jsonl")
+			err := os.WriteFile(cacheFile, []byte(test.line()+"\n"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cache := &manifestCache{entries: make(map[digest]*manifestCacheEntry)}
+			err = cache.load(cacheFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(cache.entries) != test.entries || cache.invalid != test.invalid {
+				t.Fatalf("expected %d entries and %d invalid, got %d and %d",
+					test.entries, test.invalid, len(cache.entries), cache.invalid)
+			}
+
+			// invalid entries are dropped by saving the cache
+			if cache.changed != (test.invalid > 0) {
+				t.Fatalf("expected cache to be changed: %v", test.invalid > 0)
+			}
+		})
+	}
+}
+
+func TestManifestCacheRuns(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "manifests.jsonl")
+
+	tests := []struct {
+		name    string
+		prepare func(r *testRegistry, image *testImage)
+		hits    int64
+		misses  int64
+		entries int
+	}{
+		{
+			name:    "first run",
+			misses:  3,
+			entries: 3,
+		},
+		{
+			// manifests are not read again, even when they are no longer readable
+			name: "second run",
+			prepare: func(r *testRegistry, image *testImage) {
+				for _, manifest := range []string{image.index, image.ociImage, image.image} {
+					r.put(testBlobPath(manifest), "not readable")
+				}
+			},
+			hits:    3,
+			entries: 3,
+		},
+		{
+			name: "corrupted cache",
+			prepare: func(r *testRegistry, image *testImage) {
+				data, err := os.ReadFile(cacheFile)
+				if err != nil {
+					r.t.Fatal(err)
+				}
+
+				// entry of the index is no longer valid
+				lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+				for idx, line := range lines {
+					if strings.Contains(line, image.index) && strings.Contains(line, mediaTypeOCIIndex) {
+						lines[idx] = strings.Replace(line, image.ociImage, image.oldImage, 1)
+					}
+				}
+
+				err = os.WriteFile(cacheFile, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+				if err != nil {
+					r.t.Fatal(err)
+				}
+			},
+			hits:    2,
+			misses:  1,
+			entries: 3,
+		},
+		{
+			// the index is no longer used, so it and the OCI image are dropped from cache
+			name: "tag moved",
+			prepare: func(r *testRegistry, image *testImage) {
+				for _, manifest := range []string{image.index, image.oldImage} {
+					err := currentStorage.Delete(testTagVersionPath(manifest))
+					if err != nil {
+						r.t.Fatal(err)
+					}
+				}
+				r.tag(image.image)
+			},
+			hits:    1,
+			entries: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+			image := newTestImage(r)
+			if test.prepare != nil {
+				test.prepare(r, image)
+			}
+
+			var err error
+			currentManifestCache, err = loadManifestCache(cacheFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// cache is saved at exit of the run
+			r.prune()
+
+			if currentManifestCache.hits != test.hits || currentManifestCache.misses != test.misses {
+				t.Fatalf("expected %d hits and %d misses, got %d and %d",
+					test.hits, test.misses, currentManifestCache.hits, currentManifestCache.misses)
+			}
+
+			cache, err := loadManifestCache(cacheFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runExitHandlers()
+
+			// unused manifest is never loaded
+			if len(cache.entries) != test.entries || cache.invalid != 0 {
+				t.Fatalf("expected %d valid entries to be saved, got %d and %d invalid", test.entries, len(cache.entries), cache.invalid)
+			}
+		})
+	}
+}
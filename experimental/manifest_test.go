@@ -0,0 +1,269 @@
+package experimental
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeserializeManifest(t *testing.T) {
+	config := `{"mediaType":"application/vnd.oci.image.config.v1+json","size":1,"digest":"sha256:b79606fb3afea5bd1609ed40b622142f1c98125abcfe89a76a661b0e8e343910"}`
+	layer := `{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","size":1,"digest":"sha256:95cf1a2e1698fe3ca1fcc3f653119146b271d0b62e487ec264441e886a11bd06"}`
+	manifest := `{"mediaType":"application/vnd.oci.image.manifest.v1+json","size":1,"digest":"sha256:567887c05cd8349a5434f642c8ad2331648933f8abd6a932394e3ecd88dfaf87","platform":{"architecture":"amd64","os":"linux"}}`
+
+	tests := []struct {
+		name       string
+		data       string
+		mediaType  string
+		list       bool
+		references int
+		valid      bool
+	}{
+		{
+			name:       "docker image",
+			data:       `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":` + config + `,"layers":[` + layer + `]}`,
+			mediaType:  "application/vnd.docker.distribution.manifest.v2+json",
+			references: 2,
+			valid:      true,
+		},
+		{
+			name:       "docker manifest list",
+			data:       `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[` + manifest + `]}`,
+			mediaType:  "application/vnd.docker.distribution.manifest.list.v2+json",
+			list:       true,
+			references: 1,
+			valid:      true,
+		},
+		{
+			name:       "oci image",
+			data:       `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":` + config + `,"layers":[` + layer + `,` + layer + `]}`,
+			mediaType:  mediaTypeOCIManifest,
+			references: 3,
+			valid:      true,
+		},
+		{
+			name:       "oci image without media type",
+			data:       `{"schemaVersion":2,"config":` + config + `,"layers":[]}`,
+			mediaType:  mediaTypeOCIManifest,
+			references: 1,
+			valid:      true,
+		},
+		{
+			name:       "oci index",
+			data:       `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[` + manifest + `,` + manifest + `]}`,
+			mediaType:  mediaTypeOCIIndex,
+			list:       true,
+			references: 2,
+			valid:      true,
+		},
+		{
+			name:       "oci index without media type",
+			data:       `{"schemaVersion":2,"manifests":[` + manifest + `]}`,
+			mediaType:  mediaTypeOCIIndex,
+			list:       true,
+			references: 1,
+			valid:      true,
+		},
+		{
+			name: "unknown media type",
+			data: `{"schemaVersion":2,"mediaType":"application/vnd.unknown+json"}`,
+		},
+		{
+			name: "unknown document",
+			data: `{"schemaVersion":2,"annotations":{}}`,
+		},
+		{
+			name: "unknown schema version",
+			data: `{"schemaVersion":3}`,
+		},
+		{
+			name: "invalid json",
+			data: `{"schemaVersion":`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest, err := deserializeManifest([]byte(test.data))
+			if !test.valid {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			mediaType, payload, err := manifest.Payload()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mediaType != test.mediaType {
+				t.Fatalf("expected media type %s, got %s", test.mediaType, mediaType)
+			} else if string(payload) != test.data {
+				t.Fatalf("expected payload %s, got %s", test.data, payload)
+			} else if isManifestList(manifest) != test.list {
+				t.Fatalf("expected manifest list: %v", test.list)
+			} else if len(manifest.References()) != test.references {
+				t.Fatalf("expected %d references, got %d", test.references, len(manifest.References()))
+			}
+		})
+	}
+}
+
+func setTestFlag[T any](t *testing.T, value *T, newValue T) {
+	oldValue := *value
+	*value = newValue
+	t.Cleanup(func() { *value = oldValue })
+}
+
+// testManifests writes blobs and manifests to filesystem storage of the test
+type testManifests struct {
+	t       *testing.T
+	storage *fsStorage
+}
+
+func newTestManifests(t *testing.T) *testManifests {
+	storage := &fsStorage{distributionStorageFilesystem: &distributionStorageFilesystem{RootDirectory: t.TempDir()}}
+	setTestFlag(t, &currentStorage, storageObject(storage))
+	setTestFlag(t, &manifests, make(manifestsData))
+	return &testManifests{t: t, storage: storage}
+}
+
+func (m *testManifests) put(path string, data string) {
+	fullPath := m.storage.livePath(path)
+	err := os.MkdirAll(filepath.Dir(fullPath), 0700)
+	if err == nil {
+		err = os.WriteFile(fullPath, []byte(data), 0600)
+	}
+	if err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+func (m *testManifests) blob(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	digestHex := hex.EncodeToString(hash[:])
+	m.put(filepath.Join("blobs", "sha256", digestHex[0:2], digestHex, "data"), data)
+	return digestHex
+}
+
+func (m *testManifests) json(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return m.blob(string(data))
+}
+
+func testManifestDescriptor(mediaType, digestHex string) map[string]interface{} {
+	return map[string]interface{}{"mediaType": mediaType, "size": 1, "digest": digestReferenceAlgorithm + digestHex}
+}
+
+func (m *testManifests) image(mediaType, config string, layers ...string) string {
+	var descriptors []interface{}
+	for _, layer := range layers {
+		descriptors = append(descriptors, testManifestDescriptor("application/vnd.docker.image.rootfs.diff.tar.gzip", layer))
+	}
+
+	return m.json(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaType,
+		"config":        testManifestDescriptor("application/vnd.docker.container.image.v1+json", config),
+		"layers":        descriptors,
+	})
+}
+
+func (m *testManifests) index(manifests ...string) string {
+	var descriptors []interface{}
+	for _, manifest := range manifests {
+		descriptors = append(descriptors, testManifestDescriptor(mediaTypeOCIManifest, manifest))
+	}
+
+	return m.json(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIIndex,
+		"manifests":     descriptors,
+	})
+}
+
+func TestManifestDataLoad(t *testing.T) {
+	m := newTestManifests(t)
+
+	config := m.blob("config")
+	layers := []string{m.blob("layer1"), m.blob("layer2")}
+	ociImage := m.image(mediaTypeOCIManifest, config, layers[0])
+	image := m.image("application/vnd.docker.distribution.manifest.v2+json", config, layers[1])
+	imageIndex := m.index(ociImage, image)
+
+	index, err := newBlobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.close() })
+	blobs := blobsData{index: index}
+
+	tests := []struct {
+		name      string
+		digest    string
+		mediaType string
+		layers    []string
+		manifests []string
+	}{
+		{
+			name:      "oci image",
+			digest:    ociImage,
+			mediaType: mediaTypeOCIManifest,
+			layers:    []string{config, layers[0]},
+		},
+		{
+			name:      "docker image",
+			digest:    image,
+			mediaType: "application/vnd.docker.distribution.manifest.v2+json",
+			layers:    []string{config, layers[1]},
+		},
+		{
+			name:      "oci index",
+			digest:    imageIndex,
+			mediaType: mediaTypeOCIIndex,
+			manifests: []string{ociImage, image},
+		},
+	}
+
+	hexes := func(digests []digest) []string {
+		var result []string
+		for _, digest := range digests {
+			result = append(result, digest.hexHash())
+		}
+		return result
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var d digest
+			err := d.decode([]byte(test.digest))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manifest := &manifestData{digest: d}
+			err = manifest.load(blobs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if manifest.mediaType != test.mediaType {
+				t.Fatalf("expected media type %s, got %s", test.mediaType, manifest.mediaType)
+			} else if layers := hexes(manifest.layers); !reflect.DeepEqual(layers, test.layers) {
+				t.Fatalf("expected layers %v, got %v", test.layers, layers)
+			} else if manifests := hexes(manifest.manifests); !reflect.DeepEqual(manifests, test.manifests) {
+				t.Fatalf("expected manifests %v, got %v", test.manifests, manifests)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package experimental
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	inMemorySnapshot = flag.String("inmemory-snapshot", "", "Load in-memory storage from this tarball or JSON snapshot")
+	inMemoryDump     = flag.String("inmemory-dump", "", "Write in-memory storage to this tarball or JSON snapshot at the end of the run")
+)
+
+type memoryObject struct {
+	Path         string    `json:"path"`
+	Data         []byte    `json:"data"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type memorySnapshot struct {
+	Objects []*memoryObject `json:"objects"`
+}
+
+// memoryData keeps objects by their keys, lock serializes changes
+// that need to check other objects, like moves
+type memoryData struct {
+	objects sync.Map
+	lock    sync.Mutex
+}
+
+// memoryStorage keeps all objects in memory, it allows to simulate
+// runs against snapshot of production registry without touching it
+type memoryStorage struct {
+	*memoryData
+	backup bool
+}
+
+func (f *memoryStorage) fullPath(path string) string {
+	if f.backup {
+		return f.backupPath(path)
+	}
+	return f.livePath(path)
+}
+
+func (f *memoryStorage) livePath(path string) string {
+	return filepath.Join("docker", "registry", "v2", path)
+}
+
+func (f *memoryStorage) backupPath(path string) string {
+	return filepath.Join("docker-backup", "registry", "v2", path)
+}
+
+func (o *memoryObject) fileInfo() fileInfo {
+	hash := md5.Sum(o.Data)
+	return fileInfo{
+		fullPath:     o.Path,
+		size:         int64(len(o.Data)),
+		etag:         "\"" + hex.EncodeToString(hash[:]) + "\"",
+		lastModified: o.LastModified,
+	}
+}
+
+// find returns sorted objects that are stored under prefix
+func (f *memoryStorage) find(prefix string) []*memoryObject {
+	var objects []*memoryObject
+	f.objects.Range(func(key, object interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			objects = append(objects, object.(*memoryObject))
+		}
+		return true
+	})
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Path < objects[j].Path
+	})
+	return objects
+}
+
+func (f *memoryStorage) Walk(path string, baseDir string, fn walkFunc) error {
+	path = f.fullPath(path) + "/"
+	baseDir = f.fullPath(baseDir) + "/"
+
+	for _, object := range f.find(path) {
+		err := fn(strings.TrimPrefix(object.Path, baseDir), object.fileInfo(), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *memoryStorage) List(path string, fn walkFunc) error {
+	path = f.fullPath(path) + "/"
+	directories := make(map[string]bool)
+
+	for _, object := range f.find(path) {
+		keyPath := strings.TrimPrefix(object.Path, path)
+
+		if idx := strings.Index(keyPath, "/"); idx >= 0 {
+			directory := keyPath[0:idx]
+			if directories[directory] {
+				continue
+			}
+			directories[directory] = true
+
+			err := fn(directory, fileInfo{fullPath: path + directory, directory: true}, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := fn(keyPath, object.fileInfo(), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *memoryStorage) get(key string) *memoryObject {
+	object, ok := f.objects.Load(key)
+	if !ok {
+		return nil
+	}
+	return object.(*memoryObject)
+}
+
+func (f *memoryStorage) Read(path string, etag string) ([]byte, error) {
+	object := f.get(f.fullPath(path))
+	if object == nil {
+		return nil, &os.PathError{Op: "read", Path: path, Err: os.ErrNotExist}
+	}
+	return object.Data, nil
+}
+
+func (f *memoryStorage) Delete(path string) error {
+	key := f.fullPath(path)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.objects.LoadAndDelete(key); !ok {
+		return &os.PathError{Op: "delete", Path: path, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (f *memoryStorage) Move(path, newPath string) error {
+	return f.move(path, newPath, false)
+}
+
+func (f *memoryStorage) MoveExclusive(path, newPath string) error {
+	return f.move(path, newPath, true)
+}
+
+func (f *memoryStorage) move(path, newPath string, exclusive bool) error {
+	key := f.fullPath(path)
+
+	// Moving out of backup restores data
+	if f.backup {
+		newPath = f.livePath(newPath)
+	} else {
+		newPath = f.backupPath(newPath)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	object := f.get(key)
+	if object == nil {
+		return &os.PathError{Op: "move", Path: path, Err: os.ErrNotExist}
+	}
+
+	if exclusive && f.get(newPath) != nil {
+		return &os.PathError{Op: "move", Path: newPath, Err: os.ErrExist}
+	}
+
+	f.objects.Delete(key)
+	f.objects.Store(newPath, &memoryObject{
+		Path:         newPath,
+		Data:         object.Data,
+		LastModified: time.Now(),
+	})
+	return nil
+}
+
+func (f *memoryStorage) Backup() storageObject {
+	return &memoryStorage{memoryData: f.memoryData, backup: true}
+}
+
+func (f *memoryStorage) Info() {
+	var objects, size int64
+	f.objects.Range(func(key, object interface{}) bool {
+		objects++
+		size += int64(len(object.(*memoryObject).Data))
+		return true
+	})
+
+	logrus.Infoln("MEMORY INFO: Objects/Data:", objects, "/", humanize.Bytes(uint64(size)))
+}
+
+func (f *memoryStorage) add(path string, data []byte, lastModified time.Time) {
+	path = strings.TrimPrefix(filepath.Clean(path), "/")
+
+	f.objects.Store(path, &memoryObject{
+		Path:         path,
+		Data:         data,
+		LastModified: lastModified,
+	})
+}
+
+func isJSONSnapshot(snapshotFile string) bool {
+	return strings.HasSuffix(snapshotFile, ".json")
+}
+
+func isGzipSnapshot(snapshotFile string) bool {
+	return strings.HasSuffix(snapshotFile, ".gz") || strings.HasSuffix(snapshotFile, ".tgz")
+}
+
+func (f *memoryStorage) loadTar(reader io.Reader) error {
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return err
+		}
+
+		f.add(header.Name, data, header.ModTime)
+	}
+}
+
+func (f *memoryStorage) load(snapshotFile string) error {
+	file, err := os.Open(snapshotFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if isJSONSnapshot(snapshotFile) {
+		snapshot := &memorySnapshot{}
+		err = json.NewDecoder(file).Decode(snapshot)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range snapshot.Objects {
+			f.add(object.Path, object.Data, object.LastModified)
+		}
+		return nil
+	}
+
+	if isGzipSnapshot(snapshotFile) {
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		return f.loadTar(reader)
+	}
+
+	return f.loadTar(file)
+}
+
+func (f *memoryStorage) dumpTar(writer io.Writer) error {
+	archive := tar.NewWriter(writer)
+
+	for _, object := range f.find("") {
+		err := archive.WriteHeader(&tar.Header{
+			Name:     object.Path,
+			Mode:     0600,
+			Size:     int64(len(object.Data)),
+			ModTime:  object.LastModified,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = archive.Write(object.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func (f *memoryStorage) dump(snapshotFile string) error {
+	file, err := os.Create(snapshotFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if isJSONSnapshot(snapshotFile) {
+		return json.NewEncoder(file).Encode(&memorySnapshot{Objects: f.find("")})
+	}
+
+	if isGzipSnapshot(snapshotFile) {
+		writer := gzip.NewWriter(file)
+		err = f.dumpTar(writer)
+		if err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+
+	return f.dumpTar(file)
+}
+
+func newMemoryStorage() (storageObject, error) {
+	storage := &memoryStorage{memoryData: &memoryData{}}
+
+	if *inMemorySnapshot != "" {
+		err := storage.load(*inMemorySnapshot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *inMemoryDump != "" {
+		atExit(func() {
+			err := storage.dump(*inMemoryDump)
+			if err != nil {
+				logrus.Errorln("Failed to dump in-memory storage:", err)
+			}
+		})
+	}
+
+	return storage, nil
+}
@@ -0,0 +1,457 @@
+package experimental
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testRegistry struct {
+	t       *testing.T
+	storage *memoryStorage
+}
+
+// testImage is layout of group/app repository created by newTestImage
+type testImage struct {
+	layers   []string
+	config   string
+	orphan   string
+	ociImage string
+	image    string
+	oldImage string
+	index    string
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	startTestRunners()
+
+	storage, err := newMemoryStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentStorage = storage
+	currentPlan = nil
+	currentPlanWriter = nil
+	currentManifestCache = nil
+	setTestFlag(t, &repositoryLinks, nil)
+	manifests = make(manifestsData)
+	runStartedAt = time.Now()
+	deletedLinks, deletedBlobs, deletedUploads, deletedOther, deletedBlobSize = 0, 0, 0, 0, 0
+	restoredObjects, restoredSize = 0, 0
+	t.Cleanup(func() { currentStorage = nil })
+
+	return &testRegistry{t: t, storage: storage.(*memoryStorage)}
+}
+
+func (r *testRegistry) put(path string, data string) {
+	r.storage.add(r.storage.livePath(path), []byte(data), runStartedAt.Add(-time.Hour))
+}
+
+func (r *testRegistry) blob(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	digestHex := hex.EncodeToString(hash[:])
+	r.put(filepath.Join("blobs", "sha256", digestHex[0:2], digestHex, "data"), data)
+	return digestHex
+}
+
+func (r *testRegistry) link(path string, digestHex string) {
+	r.put(path, digestReferenceAlgorithm+digestHex)
+}
+
+func (r *testRegistry) json(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return r.blob(string(data))
+}
+
+func (r *testRegistry) manifest(mediaType, config string, layers ...string) string {
+	var descriptors []interface{}
+	for _, layer := range layers {
+		descriptors = append(descriptors, testManifestDescriptor("application/vnd.docker.image.rootfs.diff.tar.gzip", layer))
+	}
+
+	return r.json(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaType,
+		"config":        testManifestDescriptor("application/vnd.docker.container.image.v1+json", config),
+		"layers":        descriptors,
+	})
+}
+
+func (r *testRegistry) index(manifests ...string) string {
+	var descriptors []interface{}
+	for _, manifest := range manifests {
+		descriptors = append(descriptors, testManifestDescriptor(mediaTypeOCIManifest, manifest))
+	}
+
+	return r.json(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIIndex,
+		"manifests":     descriptors,
+	})
+}
+
+// tag points latest tag to the first manifest, the other ones are its previous versions
+func (r *testRegistry) tag(manifests ...string) {
+	r.link("repositories/group/app/_manifests/tags/latest/current/link", manifests[0])
+	for _, manifest := range manifests {
+		r.link(testTagVersionPath(manifest), manifest)
+	}
+}
+
+func (r *testRegistry) paths(prefix string) []string {
+	var paths []string
+	for _, object := range r.storage.find(prefix) {
+		paths = append(paths, strings.TrimPrefix(object.Path, prefix))
+	}
+	return paths
+}
+
+func (r *testRegistry) livePaths() []string {
+	return r.paths(r.storage.livePath("") + "/")
+}
+
+func (r *testRegistry) backupPaths() []string {
+	return r.paths(r.storage.backupPath("") + "/")
+}
+
+// newTestImage creates repository with tag pointing to index of two images,
+// previous version of tag points to third image, that is no longer used
+func newTestImage(r *testRegistry) *testImage {
+	image := &testImage{
+		layers: []string{r.blob("layer1"), r.blob("layer2"), r.blob("layer3")},
+		config: r.blob("config"),
+		orphan: r.blob("orphan"),
+	}
+	image.ociImage = r.manifest(mediaTypeOCIManifest, image.config, image.layers[0])
+	image.image = r.manifest("application/vnd.docker.distribution.manifest.v2+json", image.config, image.layers[1])
+	image.oldImage = r.manifest("application/vnd.docker.distribution.manifest.v2+json", image.config, image.layers[2])
+	image.index = r.index(image.ociImage, image.image)
+
+	for _, layer := range append(image.layers, image.config) {
+		r.link(testLayerPath(layer), layer)
+	}
+	for _, manifest := range []string{image.ociImage, image.image, image.oldImage, image.index} {
+		r.link(testRevisionPath(manifest), manifest)
+	}
+
+	r.tag(image.index, image.oldImage)
+	return image
+}
+
+// unused returns paths that are no longer referenced by the tag
+func (i *testImage) unused() []string {
+	return sortedPaths(
+		testBlobPath(i.orphan),
+		testBlobPath(i.oldImage),
+		testBlobPath(i.layers[2]),
+		testLayerPath(i.layers[2]),
+		testRevisionPath(i.oldImage),
+		testTagVersionPath(i.oldImage),
+	)
+}
+
+func prefixed(prefix string, paths []string) []string {
+	var result []string
+	for _, path := range paths {
+		result = append(result, prefix+path)
+	}
+	return result
+}
+
+// prune runs mark and sweep like Main does
+func (r *testRegistry) prune() {
+	defer runExitHandlers()
+
+	blobs, err := newBlobsData()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	repositories, err := newRepositoriesData()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	steps := []func() error{
+		func() error { return prepareCheckpoints("config.yml") },
+		func() error { return repositories.walk(false) },
+		func() error { return blobs.walk(false) },
+		func() error { return repositories.mark(blobs) },
+		func() error {
+			if currentManifestCache != nil {
+				currentManifestCache.dropUnseen()
+			}
+			return nil
+		},
+		clearCheckpoints,
+		repositories.sweep,
+		blobs.sweep,
+	}
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			r.t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryStorageMarkAndSweep(t *testing.T) {
+	tests := []struct {
+		name       string
+		delete     bool
+		softDelete bool
+		indexDir   bool
+		deleted    bool
+		backup     bool
+	}{
+		{name: "dry run"},
+		{name: "delete", delete: true, deleted: true},
+		{name: "soft delete", delete: true, softDelete: true, deleted: true, backup: true},
+		{name: "delete with disk index", delete: true, indexDir: true, deleted: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+			image := newTestImage(r)
+			all := r.livePaths()
+
+			setTestFlag(t, delete, test.delete)
+			setTestFlag(t, softDelete, test.softDelete)
+			if test.indexDir {
+				setTestFlag(t, blobIndexDir, t.TempDir())
+			}
+
+			r.prune()
+
+			expectedLive, expectedBackup := all, []string(nil)
+			if test.deleted {
+				expectedLive = without(all, image.unused())
+			}
+			if test.backup {
+				expectedBackup = prefixed("backup/", image.unused())
+			}
+
+			assertPaths(t, "live paths", expectedLive, r.livePaths())
+			assertPaths(t, "backup paths", expectedBackup, r.backupPaths())
+
+			if deletedLinks != 3 || deletedBlobs != 3 {
+				t.Fatalf("expected 3 links and 3 blobs to be deleted, got %d and %d", deletedLinks, deletedBlobs)
+			}
+		})
+	}
+}
+
+func TestMemoryStorageRestore(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository string
+		digest     func(image *testImage) string
+		restored   func(image *testImage) []string
+	}{
+		{
+			name:     "all",
+			restored: (*testImage).unused,
+		},
+		{
+			name:       "repository",
+			repository: "group/app",
+			restored: func(image *testImage) []string {
+				// orphan is not linked from repository
+				return without(image.unused(), []string{testBlobPath(image.orphan)})
+			},
+		},
+		{
+			name:   "digest",
+			digest: func(image *testImage) string { return digestReferenceAlgorithm + image.oldImage },
+			restored: func(image *testImage) []string {
+				return sortedPaths(
+					testBlobPath(image.oldImage),
+					testRevisionPath(image.oldImage),
+					testTagVersionPath(image.oldImage),
+				)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+			image := newTestImage(r)
+			all := r.livePaths()
+
+			setTestFlag(t, delete, true)
+			setTestFlag(t, softDelete, true)
+			r.prune()
+
+			setTestFlag(t, restoreRepository, test.repository)
+			if test.digest != nil {
+				setTestFlag(t, restoreDigest, test.digest(image))
+			}
+
+			err := restoreBackup()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored := test.restored(image)
+			assertPaths(t, "live paths", without(all, without(image.unused(), restored)), r.livePaths())
+			assertPaths(t, "backup paths", prefixed("backup/", without(image.unused(), restored)), r.backupPaths())
+
+			if int(restoredObjects) != len(restored) {
+				t.Fatalf("expected %d objects to be restored, got %d", len(restored), restoredObjects)
+			}
+		})
+	}
+}
+
+func TestMemoryStorageRestoreDoesNotOverwrite(t *testing.T) {
+	r := newTestRegistry(t)
+	image := newTestImage(r)
+
+	setTestFlag(t, delete, true)
+	setTestFlag(t, softDelete, true)
+	r.prune()
+
+	// layer link pushed again, after it was soft-deleted
+	r.put(testLayerPath(image.layers[2]), "pushed again")
+
+	err := restoreBackup()
+	if err == nil {
+		t.Fatal("expected restore to refuse overwriting live data")
+	}
+
+	data, err := currentStorage.Read(testLayerPath(image.layers[2]), "")
+	if err != nil || string(data) != "pushed again" {
+		t.Fatalf("expected live data to be kept, got %q %v", data, err)
+	}
+}
+
+func TestMemoryStoragePlan(t *testing.T) {
+	tests := []struct {
+		name string
+		// skipped are removed from the plan, before it is applied
+		skipped func(image *testImage) []string
+		delete  bool
+	}{
+		{name: "whole plan", delete: true},
+		{
+			name:   "partial plan",
+			delete: true,
+			skipped: func(image *testImage) []string {
+				return sortedPaths(testBlobPath(image.orphan), testTagVersionPath(image.oldImage))
+			},
+		},
+		{name: "without delete"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+			image := newTestImage(r)
+			all := r.livePaths()
+
+			planFile := filepath.Join(t.TempDir(), "plan.jsonl")
+			setTestFlag(t, softDelete, false)
+
+			var err error
+			currentPlanWriter, err = newPlanWriter(planFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.prune()
+
+			err = currentPlanWriter.close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			currentPlanWriter = nil
+			assertPaths(t, "live paths after planning", all, r.livePaths())
+
+			currentPlan, err = loadDeletionPlan(planFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var planned []string
+			for _, entry := range currentPlan.entries {
+				planned = append(planned, entry.Path)
+			}
+			assertPaths(t, "planned paths", image.unused(), sortedPaths(planned...))
+
+			var skipped []string
+			if test.skipped != nil {
+				skipped = test.skipped(image)
+				entries := make(map[string]*planEntry)
+				for key, entry := range currentPlan.entries {
+					if !containsPath(skipped, entry.Path) {
+						entries[key] = entry
+					}
+				}
+				currentPlan.entries = entries
+			}
+
+			setTestFlag(t, delete, test.delete)
+			r.prune()
+
+			expected := all
+			if test.delete {
+				expected = without(all, without(image.unused(), skipped))
+			}
+			assertPaths(t, "live paths after applying plan", expected, r.livePaths())
+		})
+	}
+}
+
+func TestMemoryStorageSnapshot(t *testing.T) {
+	for _, snapshotFile := range []string{"snapshot.json", "snapshot.tar", "snapshot.tgz"} {
+		t.Run(snapshotFile, func(t *testing.T) {
+			r := newTestRegistry(t)
+			newTestImage(r)
+			objects := r.storage.find("")
+
+			snapshotFile = filepath.Join(t.TempDir(), snapshotFile)
+			err := r.storage.dump(snapshotFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			setTestFlag(t, inMemorySnapshot, snapshotFile)
+			storage, err := newMemoryStorage()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := storage.(*memoryStorage).find("")
+			if len(loaded) != len(objects) {
+				t.Fatalf("expected %d objects, got %d", len(objects), len(loaded))
+			}
+			for idx, object := range objects {
+				// tarballs keep modification time in seconds
+				drift := loaded[idx].LastModified.Sub(object.LastModified)
+				if loaded[idx].Path != object.Path || string(loaded[idx].Data) != string(object.Data) ||
+					drift <= -time.Second || drift >= time.Second {
+					t.Fatalf("expected %s modified at %v, got %s modified at %v",
+						object.Path, object.LastModified, loaded[idx].Path, loaded[idx].LastModified)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStorageObject(t *testing.T) {
+	r := newTestRegistry(t)
+
+	testStorage(t, r.storage, func(path string, data []byte) error {
+		r.put(path, string(data))
+		return nil
+	})
+}
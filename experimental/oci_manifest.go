@@ -0,0 +1,76 @@
+package experimental
+
+import (
+	"encoding/json"
+
+	"github.com/docker/distribution"
+)
+
+const (
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+)
+
+type ociManifest struct {
+	SchemaVersion int                       `json:"schemaVersion"`
+	MediaType     string                    `json:"mediaType,omitempty"`
+	Config        distribution.Descriptor   `json:"config"`
+	Layers        []distribution.Descriptor `json:"layers"`
+
+	payload []byte
+}
+
+func (m *ociManifest) References() []distribution.Descriptor {
+	references := make([]distribution.Descriptor, 0, 1+len(m.Layers))
+	references = append(references, m.Config)
+	references = append(references, m.Layers...)
+	return references
+}
+
+func (m *ociManifest) Payload() (string, []byte, error) {
+	return mediaTypeOCIManifest, m.payload, nil
+}
+
+type ociIndex struct {
+	SchemaVersion int                       `json:"schemaVersion"`
+	MediaType     string                    `json:"mediaType,omitempty"`
+	Manifests     []distribution.Descriptor `json:"manifests"`
+
+	payload []byte
+}
+
+func (m *ociIndex) References() []distribution.Descriptor {
+	return m.Manifests
+}
+
+func (m *ociIndex) Payload() (string, []byte, error) {
+	return mediaTypeOCIIndex, m.payload, nil
+}
+
+func deserializeOCIManifest(data []byte) (distribution.Manifest, error) {
+	m := &ociManifest{payload: data}
+	err := json.Unmarshal(data, m)
+	return m, err
+}
+
+func deserializeOCIIndex(data []byte) (distribution.Manifest, error) {
+	m := &ociIndex{payload: data}
+	err := json.Unmarshal(data, m)
+	return m, err
+}
+
+// mediaType is optional in OCI documents, so fall back to detecting
+// the document type from the fields that are present
+func detectOCIMediaType(data []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+
+	if _, ok := fields["manifests"]; ok {
+		return mediaTypeOCIIndex
+	} else if _, ok := fields["config"]; ok {
+		return mediaTypeOCIManifest
+	}
+	return ""
+}
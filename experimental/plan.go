@@ -0,0 +1,178 @@
+package experimental
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var (
+	planOutput = flag.String("plan-output", "", "File to which deletion plan will be written as JSON lines")
+	applyPlan  = flag.String("apply-plan", "", "Delete only data listed in this deletion plan, that is still deletable (requires -delete)")
+)
+
+type planEntry struct {
+	Path       string `json:"path"`
+	Kind       string `json:"kind"`
+	Size       int64  `json:"size"`
+	Repository string `json:"repository,omitempty"`
+	Reason     string `json:"reason"`
+	// ID identifies S3 object version or multipart upload
+	ID string `json:"id,omitempty"`
+}
+
+type planWriter struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	lock    sync.Mutex
+}
+
+type deletionPlan struct {
+	entries map[string]*planEntry
+	applied map[string]bool
+	lock    sync.Mutex
+}
+
+var (
+	currentPlanWriter *planWriter
+	currentPlan       *deletionPlan
+)
+
+func newPlanEntry(path string, size int64, reason string) *planEntry {
+	entry := &planEntry{
+		Path:   path,
+		Size:   size,
+		Reason: reason,
+	}
+
+	segments := strings.Split(path, "/")
+	if segments[0] == "blobs" {
+		entry.Kind = "blob"
+		return entry
+	} else if segments[0] != "repositories" {
+		entry.Kind = "other"
+		return entry
+	}
+
+	for idx := 1; idx < len(segments); idx++ {
+		switch segments[idx] {
+		case "_layers":
+			entry.Kind = "layer"
+		case "_uploads":
+			entry.Kind = "upload"
+		case "_manifests":
+			if idx+1 < len(segments) && segments[idx+1] == "tags" {
+				entry.Kind = "tag"
+			} else if strings.Contains(path, "/signatures/") {
+				entry.Kind = "signature"
+			} else {
+				entry.Kind = "manifest"
+			}
+		default:
+			continue
+		}
+
+		entry.Repository = strings.Join(segments[1:idx], "/")
+		return entry
+	}
+
+	entry.Kind = "other"
+	return entry
+}
+
+func (e *planEntry) key() string {
+	return e.Path + "\x00" + e.ID
+}
+
+func (w *planWriter) write(entry *planEntry) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.encoder.Encode(entry)
+}
+
+func (w *planWriter) close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	err := w.buffer.Flush()
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func newPlanWriter(planFile string) (*planWriter, error) {
+	file, err := os.Create(planFile)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := bufio.NewWriter(file)
+	return &planWriter{
+		file:    file,
+		buffer:  buffer,
+		encoder: json.NewEncoder(buffer),
+	}, nil
+}
+
+// allows verifies that entry is still exactly as it was planned
+func (p *deletionPlan) allows(entry *planEntry) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	planned := p.entries[entry.key()]
+	if planned == nil || planned.Kind != entry.Kind || planned.Size != entry.Size {
+		return false
+	}
+
+	p.applied[entry.key()] = true
+	return true
+}
+
+func (p *deletionPlan) info() {
+	var stale int
+	for key, entry := range p.entries {
+		// Versions are purged for planned data that was deleted
+		if entry.Kind == "version" || p.applied[key] {
+			continue
+		}
+
+		logrus.Infoln("PLAN:", entry.Path, entry.ID, ": is no longer deletable, skipped")
+		stale++
+	}
+
+	logrus.Warningln("PLAN INFO:", len(p.applied), "applied,", stale, "skipped")
+}
+
+func loadDeletionPlan(planFile string) (*deletionPlan, error) {
+	file, err := os.Open(planFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	plan := &deletionPlan{
+		entries: make(map[string]*planEntry),
+		applied: make(map[string]bool),
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		entry := &planEntry{}
+		err := decoder.Decode(entry)
+		if err != nil {
+			return nil, err
+		}
+		plan.entries[entry.key()] = entry
+	}
+
+	return plan, nil
+}
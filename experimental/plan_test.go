@@ -0,0 +1,101 @@
+package experimental
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewPlanEntry(t *testing.T) {
+	tests := []struct {
+		path       string
+		kind       string
+		repository string
+	}{
+		{"blobs/sha256/57/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/data", "blob", ""},
+		{"repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link", "layer", "group/app"},
+		{"repositories/group/sub/app/_manifests/revisions/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link", "manifest", "group/sub/app"},
+		{"repositories/app/_manifests/revisions/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/signatures/sha256/708519982eae159899e908639f5fa22d23d247ad923f6e6ad6128894c5d497a0/link", "signature", "app"},
+		{"repositories/group/app/_manifests/tags/latest/current/link", "tag", "group/app"},
+		{"repositories/group/app/_uploads/f82d2b61-f130-4be5-b4f6-92cb18c7cf89/startedat", "upload", "group/app"},
+		{"repositories/group/app/unknown", "other", ""},
+		{"other/file", "other", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			entry := newPlanEntry(test.path, 71, "reason")
+			expected := &planEntry{Path: test.path, Kind: test.kind, Size: 71, Repository: test.repository, Reason: "reason"}
+			if !reflect.DeepEqual(entry, expected) {
+				t.Fatalf("expected %+v, got %+v", expected, entry)
+			}
+		})
+	}
+}
+
+func TestDeletionPlan(t *testing.T) {
+	layer := newPlanEntry("repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link", 71, "unreferenced layer")
+	blob := newPlanEntry("blobs/sha256/57/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/data", 1024, "unreferenced blob")
+	version := &planEntry{Path: blob.Path, Kind: "version", Size: 1024, Reason: "version of deleted object", ID: "v1"}
+	upload := &planEntry{Path: "docker/registry/v2/repositories/group/app/_uploads/x/data", Kind: "multipart-upload", ID: "upload-id"}
+
+	planFile := filepath.Join(t.TempDir(), "plan.jsonl")
+	writer, err := newPlanWriter(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []*planEntry{layer, blob, version, upload} {
+		err = writer.write(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := loadDeletionPlan(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(plan.entries))
+	}
+	if loaded := plan.entries[version.key()]; !reflect.DeepEqual(loaded, version) {
+		t.Fatalf("expected %+v, got %+v", version, loaded)
+	}
+
+	changed := func(entry *planEntry, change func(entry *planEntry)) *planEntry {
+		copy := *entry
+		change(&copy)
+		return &copy
+	}
+
+	tests := []struct {
+		name    string
+		entry   *planEntry
+		allowed bool
+	}{
+		{"planned", layer, true},
+		{"planned version of blob", version, true},
+		{"planned upload", upload, true},
+		{"size changed", changed(blob, func(entry *planEntry) { entry.Size = 2048 }), false},
+		{"kind changed", changed(layer, func(entry *planEntry) { entry.Kind = "manifest" }), false},
+		{"other version", changed(version, func(entry *planEntry) { entry.ID = "v2" }), false},
+		{"not planned", newPlanEntry("blobs/sha256/70/708519982eae159899e908639f5fa22d23d247ad923f6e6ad6128894c5d497a0/data", 1024, ""), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := plan.allows(test.entry); allowed != test.allowed {
+				t.Fatalf("expected %v, got %v", test.allowed, allowed)
+			}
+		})
+	}
+
+	if len(plan.applied) != 3 || plan.applied[blob.key()] {
+		t.Fatalf("expected only allowed entries to be applied, got %v", plan.applied)
+	}
+}
@@ -0,0 +1,51 @@
+package experimental
+
+import (
+	"flag"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var purgeBackupsOlderThan = flag.Duration("purge-backups-older-than", 0, "Permanently remove soft-deleted data older than this duration, instead of pruning")
+
+func purgeBackups(maxAge time.Duration) error {
+	logrus.Infoln("Purging BACKUP...")
+
+	backup := currentStorage.Backup()
+	deadline := time.Now().Add(-maxAge)
+	jg := deletesRunner.group()
+
+	err := backup.Walk("backup", "backup", func(path string, info fileInfo, err error) error {
+		if info.lastModified.IsZero() || info.lastModified.After(deadline) {
+			return nil
+		}
+
+		entry := newPlanEntry(filepath.Join("backup", path), info.size, "soft-deleted data older than "+maxAge.String())
+		entry.Kind = "backup"
+		if !isPlanned(entry) {
+			return nil
+		}
+
+		jg.dispatch(func() error {
+			logrus.Infoln("PURGE", path, info.size, info.lastModified)
+
+			err := deletePlanned(entry, func() error {
+				return backup.Delete(filepath.Join("backup", path))
+			})
+			if err != nil {
+				return err
+			}
+
+			countDelete(path, info.size)
+			return nil
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return jg.finish()
+}
@@ -0,0 +1,95 @@
+package experimental
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPurgeBackups(t *testing.T) {
+	const day = 24 * time.Hour
+	now := time.Now()
+
+	old := "blobs/sha256/57/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/data"
+	recent := "blobs/sha256/70/708519982eae159899e908639f5fa22d23d247ad923f6e6ad6128894c5d497a0/data"
+	oldLink := "repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link"
+
+	for _, deleted := range []bool{false, true} {
+		name := "dry run"
+		if deleted {
+			name = "delete"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			startTestRunners()
+			storage := &fsStorage{distributionStorageFilesystem: &distributionStorageFilesystem{RootDirectory: t.TempDir()}}
+			setTestFlag(t, &currentStorage, storageObject(storage))
+			setTestFlag(t, delete, deleted)
+			setTestFlag(t, &deletedBlobs, 0)
+			setTestFlag(t, &deletedLinks, 0)
+			setTestFlag(t, &deletedBlobSize, 0)
+
+			files := map[string]time.Time{
+				storage.backupPath(filepath.Join("backup", old)):     now.Add(-10 * day),
+				storage.backupPath(filepath.Join("backup", oldLink)): now.Add(-8 * day),
+				storage.backupPath(filepath.Join("backup", recent)):  now.Add(-6 * day),
+				// live data is never purged
+				storage.livePath(recent): now.Add(-10 * day),
+			}
+			for path, modifiedAt := range files {
+				err := os.MkdirAll(filepath.Dir(path), 0700)
+				if err == nil {
+					err = os.WriteFile(path, []byte("data"), 0600)
+				}
+				if err == nil {
+					err = os.Chtimes(path, modifiedAt, modifiedAt)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			planFile := filepath.Join(t.TempDir(), "plan.jsonl")
+			writer, err := newPlanWriter(planFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setTestFlag(t, &currentPlanWriter, writer)
+
+			err = purgeBackups(7 * day)
+			if err == nil {
+				err = writer.close()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for path, modifiedAt := range files {
+				_, err := os.Stat(path)
+				if purged := deleted && modifiedAt.Before(now.Add(-7*day)) && path != storage.livePath(recent); purged && !os.IsNotExist(err) {
+					t.Fatal("expected old backup to be purged:", path, err)
+				} else if !purged && err != nil {
+					t.Fatal("expected data to be kept:", path, err)
+				}
+			}
+
+			if deleted && (deletedBlobs != 1 || deletedLinks != 1 || deletedBlobSize != 8) {
+				t.Fatalf("expected purged blob and link of 8 bytes to be counted, got %d, %d, %d", deletedBlobs, deletedLinks, deletedBlobSize)
+			}
+
+			plan, err := loadDeletionPlan(planFile)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(plan.entries) != 2 {
+				t.Fatal("expected old backups in plan, got:", plan.entries)
+			}
+			for _, path := range []string{old, oldLink} {
+				entry := plan.entries[newPlanEntry(filepath.Join("backup", path), 0, "").key()]
+				if entry == nil || entry.Kind != "backup" || entry.Size != 4 {
+					t.Fatalf("expected backup of %s in plan, got %+v", path, entry)
+				}
+			}
+		})
+	}
+}
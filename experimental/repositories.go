@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -14,6 +15,24 @@ type repositoriesData map[string]*repositoryData
 
 var repositoriesLock sync.Mutex
 
+func newRepositoriesData() (repositoriesData, error) {
+	if *blobIndexDir != "" {
+		store, err := newBoltLinkStore(*blobIndexDir)
+		if err != nil {
+			return nil, err
+		}
+
+		atExit(func() {
+			err := store.close()
+			if err != nil {
+				logrus.Errorln("LINK INDEX:", err)
+			}
+		})
+		repositoryLinks = store
+	}
+	return make(repositoriesData), nil
+}
+
 func (r repositoriesData) get(path []string) *repositoryData {
 	repositoryName := strings.Join(path, "/")
 
@@ -51,7 +70,7 @@ func (r repositoriesData) process(segments []string, info fileInfo) error {
 
 func (r repositoriesData) walkPath(walkPath string, jg *jobGroup) error {
 	logrus.Infoln("REPOSITORIES DIR:", walkPath)
-	return currentStorage.Walk(walkPath, "repositories", func(path string, info fileInfo, err error) error {
+	return checkpointWalk(walkPath, "repositories", func(path string, info fileInfo, err error) error {
 		jg.dispatch(func() error {
 			err = r.process(strings.Split(path, "/"), info)
 			if err != nil {
@@ -93,6 +112,15 @@ func (r repositoriesData) walk(parallel bool) error {
 	return jg.finish()
 }
 
+func (r repositoriesData) applyTagPolicies(policies *tagPolicies) {
+	for _, repository := range r {
+		policy := policies.find(repository.name)
+		if policy != nil {
+			policy.apply(repository)
+		}
+	}
+}
+
 func (r repositoriesData) mark(blobs blobsData) error {
 	jg := jobsRunner.group()
 
@@ -111,7 +139,7 @@ func (r repositoriesData) mark(blobs blobsData) error {
 }
 
 func (r repositoriesData) sweep() error {
-	jg := jobsRunner.group()
+	jg := deletesRunner.group()
 
 	for _, repository_ := range r {
 		repository := repository_
@@ -127,6 +155,19 @@ func (r repositoriesData) sweep() error {
 	return nil
 }
 
+func (r repositoriesData) sweepUploads(maxAge time.Duration) error {
+	jg := deletesRunner.group()
+
+	for _, repository_ := range r {
+		repository := repository_
+		jg.dispatch(func() error {
+			return repository.sweepUploads(maxAge)
+		})
+	}
+
+	return jg.finish()
+}
+
 func (r repositoriesData) info(blobs blobsData, csvOutput string) {
 	var stream io.WriteCloser
 
@@ -157,6 +198,9 @@ func (r repositoriesData) info(blobs blobsData, csvOutput string) {
 	}
 
 	for _, repository := range r {
-		repository.info(blobs, stream)
+		err := repository.info(blobs, stream)
+		if err != nil {
+			logErrorln(err)
+		}
 	}
 }
@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
 
@@ -15,11 +15,11 @@ import (
 
 type repositoryData struct {
 	name               string
-	layers             map[digest]int
-	manifests          map[digest]int
+	layers             linkIndex
+	manifests          linkIndex
 	manifestSignatures map[digest][]digest
 	tags               map[string]*tagData
-	uploads            []string
+	uploads            map[string]*uploadData
 	lock               sync.Mutex
 }
 
@@ -35,8 +35,8 @@ func (r *repositoryData) manifestRevisionSignaturePath(revision, signature diges
 	return filepath.Join("repositories", r.name, "_manifests", "revisions", revision.path(), "signatures", signature.path(), "link")
 }
 
-func (r *repositoryData) uploadPath(upload string) string {
-	return filepath.Join("repositories", r.name, "_uploads", upload, "link")
+func (r *repositoryData) uploadPath(upload, file string) string {
+	return filepath.Join("repositories", r.name, "_uploads", upload, file)
 }
 
 func (r *repositoryData) tag(name string) *tagData {
@@ -56,14 +56,16 @@ func (r *repositoryData) tag(name string) *tagData {
 }
 
 func (r *repositoryData) markManifest(revision digest) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
-	r.manifests[revision]++
-	return nil
+	_, err := r.manifests.use(revision)
+	return err
 }
 
-func (r *repositoryData) markManifestLayers(blobs blobsData, revision digest) error {
+func (r *repositoryData) markManifestLayers(blobs blobsData, revision digest, visited map[digest]bool) error {
+	if visited[revision] {
+		return nil
+	}
+	visited[revision] = true
+
 	err := blobs.mark(revision)
 	if err != nil {
 		return err
@@ -74,25 +76,44 @@ func (r *repositoryData) markManifestLayers(blobs blobsData, revision digest) er
 		return err
 	}
 
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
 	var resultErr error
 	for _, layer := range manifest.layers {
-		_, ok := r.layers[layer]
-		if !ok {
+		ok, err := r.layers.use(layer)
+		if err != nil {
+			return err
+		} else if !ok {
 			resultErr = multierror.Append(resultErr, fmt.Errorf("layer %s not found reference from manifest %s", layer, revision))
 		}
+	}
+
+	var children []digest
+	for _, child := range manifest.manifests {
+		ok, err := r.manifests.mark(child)
+		if err != nil {
+			return err
+		} else if !ok {
+			resultErr = multierror.Append(resultErr, fmt.Errorf("manifest %s not found reference from manifest list %s", child, revision))
+			continue
+		}
+
+		children = append(children, child)
+	}
 
-		r.layers[layer]++
+	// manifest lists form a DAG: children are marked with their own layers
+	for _, child := range children {
+		err := r.markManifestLayers(blobs, child, visited)
+		if err != nil {
+			resultErr = multierror.Append(resultErr, err)
+		}
 	}
 
 	return resultErr
 }
 
 func (r *repositoryData) markManifestSignatures(blobs blobsData, revision digest, signatures []digest) error {
-	if r.manifests[revision] == 0 {
-		return nil
+	used, err := r.manifests.used(revision)
+	if err != nil || used == 0 {
+		return err
 	}
 
 	for _, signature := range signatures {
@@ -102,12 +123,13 @@ func (r *repositoryData) markManifestSignatures(blobs blobsData, revision digest
 }
 
 func (r *repositoryData) sweepManifestSignatures(revision digest, signatures []digest) error {
-	if r.manifests[revision] > 0 {
-		return nil
+	used, err := r.manifests.used(revision)
+	if err != nil || used > 0 {
+		return err
 	}
 
 	for _, signature := range signatures {
-		err := deleteFile(r.manifestRevisionSignaturePath(revision, signature), digestReferenceSize)
+		err := deleteFile(r.manifestRevisionSignaturePath(revision, signature), digestReferenceSize, "unreferenced manifest")
 		if err != nil {
 			return err
 		}
@@ -131,19 +153,22 @@ func (r *repositoryData) mark(blobs blobsData) error {
 		}
 	}
 
-	for revision, used := range r.manifests {
+	visited := make(map[digest]bool)
+
+	err := r.manifests.each(func(revision digest, used int64) error {
 		if used == 0 {
-			continue
+			return nil
 		}
 
-		err := r.markManifestLayers(blobs, revision)
-		if err != nil {
-			if *softErrors {
-				logrus.Errorln("MARK:", r.name, "MANIFEST:", revision, "ERROR:", err)
-				continue
-			}
-			return err
+		err := r.markManifestLayers(blobs, revision, visited)
+		if err != nil && *softErrors {
+			logrus.Errorln("MARK:", r.name, "MANIFEST:", revision, "ERROR:", err)
+			return nil
 		}
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
 	for revision, signatures := range r.manifestSignatures {
@@ -157,21 +182,18 @@ func (r *repositoryData) mark(blobs blobsData) error {
 		}
 	}
 
-	for digest, used := range r.layers {
+	return r.layers.each(func(digest digest, used int64) error {
 		if used == 0 {
-			continue
+			return nil
 		}
 
 		err := r.markLayer(blobs, digest)
-		if err != nil {
-			if *softErrors {
-				logrus.Errorln("MARK:", r.name, "LAYER:", digest, "ERROR:", err)
-				continue
-			}
-			return err
+		if err != nil && *softErrors {
+			logrus.Errorln("MARK:", r.name, "LAYER:", digest, "ERROR:", err)
+			return nil
 		}
-	}
-	return nil
+		return err
+	})
 }
 
 func (r *repositoryData) sweep() error {
@@ -186,19 +208,20 @@ func (r *repositoryData) sweep() error {
 		}
 	}
 
-	for revision, used := range r.manifests {
+	err := r.manifests.each(func(revision digest, used int64) error {
 		if used > 0 {
-			continue
+			return nil
 		}
 
-		err := deleteFile(r.manifestRevisionPath(revision), digestReferenceSize)
-		if err != nil {
-			if *softErrors {
-				logrus.Errorln("SWEEP:", r.name, "MANIFEST:", revision, "ERROR:", err)
-				continue
-			}
-			return err
+		err := deleteFile(r.manifestRevisionPath(revision), digestReferenceSize, "unreferenced manifest")
+		if err != nil && *softErrors {
+			logrus.Errorln("SWEEP:", r.name, "MANIFEST:", revision, "ERROR:", err)
+			return nil
 		}
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
 	for revision, signatures := range r.manifestSignatures {
@@ -212,22 +235,18 @@ func (r *repositoryData) sweep() error {
 		}
 	}
 
-	for digest, used := range r.layers {
+	return r.layers.each(func(digest digest, used int64) error {
 		if used > 0 {
-			continue
+			return nil
 		}
 
-		err := deleteFile(r.layerLinkPath(digest), digestReferenceSize)
-		if err != nil {
-			if *softErrors {
-				logrus.Errorln("MARK:", r.name, "LAYER:", digest, "ERROR:", err)
-				continue
-			}
-			return err
+		err := deleteFile(r.layerLinkPath(digest), digestReferenceSize, "unreferenced layer")
+		if err != nil && *softErrors {
+			logrus.Errorln("MARK:", r.name, "LAYER:", digest, "ERROR:", err)
+			return nil
 		}
-	}
-
-	return nil
+		return err
+	})
 }
 
 func (r *repositoryData) addLayer(args []string, info fileInfo) error {
@@ -242,11 +261,11 @@ func (r *repositoryData) addLayer(args []string, info fileInfo) error {
 		return err
 	}
 
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
-	r.layers[link] = 0
-	return nil
+	// links within grace period are considered used
+	if isRecent(info) {
+		return r.layers.set(link, 1)
+	}
+	return r.layers.set(link, 0)
 }
 
 func (r *repositoryData) addManifestRevision(args []string, info fileInfo) error {
@@ -258,11 +277,11 @@ func (r *repositoryData) addManifestRevision(args []string, info fileInfo) error
 			return err
 		}
 
-		r.lock.Lock()
-		defer r.lock.Unlock()
-
-		r.manifests[link] = 0
-		return nil
+		// revisions within grace period are considered used
+		if isRecent(info) {
+			return r.manifests.set(link, 1)
+		}
+		return r.manifests.set(link, 0)
 	}
 
 	link, signature, err := analyzeLinkSignature(args)
@@ -315,24 +334,48 @@ func (r *repositoryData) addManifest(args []string, info fileInfo) error {
 func (r *repositoryData) addUpload(args []string, info fileInfo) error {
 	// /test/_uploads/f82d2b61-f130-4be5-b4f6-92cb18c7cf89/startedat
 	// /test/_uploads/f82d2b61-f130-4be5-b4f6-92cb18c7cf89/hashstates/sha256/0
-	if len(args) < 1 {
+	if len(args) < 2 {
 		return fmt.Errorf("invalid args for uploads: %v", args)
 	}
 
+	return r.upload(args[0]).addFile(args[1:], info)
+}
+
+func (r *repositoryData) upload(name string) *uploadData {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	r.uploads = append(r.uploads, strings.Join(args, "/"))
+	u := r.uploads[name]
+	if u == nil {
+		u = newUploadData(r, name)
+		r.uploads[name] = u
+	}
+
+	return u
+}
+
+func (r *repositoryData) sweepUploads(maxAge time.Duration) error {
+	for name, u := range r.uploads {
+		err := u.sweep(maxAge)
+		if err != nil {
+			if *softErrors {
+				logrus.Errorln("SWEEP:", r.name, "UPLOAD:", name, "ERROR:", err)
+				continue
+			}
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (r *repositoryData) info(blobs blobsData, stream io.WriteCloser) {
+func (r *repositoryData) info(blobs blobsData, stream io.WriteCloser) error {
 	var layersUsed, layersUnused int
 	var manifestsUsed, manifestsUnused int
 	var tagsVersions int
 	var layersUsedSize, layersUnusedSize int64
 
-	for digest, used := range r.layers {
+	err := r.layers.each(func(digest digest, used int64) error {
 		if used > 0 {
 			layersUsed++
 			layersUsedSize += blobs.size(digest)
@@ -340,14 +383,22 @@ func (r *repositoryData) info(blobs blobsData, stream io.WriteCloser) {
 			layersUnused++
 			layersUnusedSize += blobs.size(digest)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	for _, used := range r.manifests {
+	err = r.manifests.each(func(revision digest, used int64) error {
 		if used > 0 {
 			manifestsUsed++
 		} else {
 			manifestsUnused++
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	for _, tag := range r.tags {
@@ -368,14 +419,16 @@ func (r *repositoryData) info(blobs blobsData, stream io.WriteCloser) {
 			humanize.Bytes(uint64(layersUsedSize)), humanize.Bytes(uint64(layersUnusedSize)),
 			layersUsedSize/1024/1024, layersUnusedSize/1024/1024)
 	}
+	return nil
 }
 
 func newRepositoryData(name string) *repositoryData {
 	return &repositoryData{
 		name:               name,
-		layers:             make(map[digest]int),
-		manifests:          make(map[digest]int),
+		layers:             newLinkIndex(name, "layers"),
+		manifests:          newLinkIndex(name, "manifests"),
 		manifestSignatures: make(map[digest][]digest),
 		tags:               make(map[string]*tagData),
+		uploads:            make(map[string]*uploadData),
 	}
 }
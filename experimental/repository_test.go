@@ -0,0 +1,267 @@
+package experimental
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var startTestRunnersOnce sync.Once
+
+func startTestRunners() {
+	startTestRunnersOnce.Do(func() {
+		jobsRunner.run(4)
+		parallelWalkRunner.run(4)
+		deletesRunner.run(4)
+	})
+}
+
+func testLayerPath(digestHex string) string {
+	return "repositories/group/app/_layers/sha256/" + digestHex + "/link"
+}
+
+func testRevisionPath(digestHex string) string {
+	return "repositories/group/app/_manifests/revisions/sha256/" + digestHex + "/link"
+}
+
+func testTagVersionPath(digestHex string) string {
+	return "repositories/group/app/_manifests/tags/latest/index/sha256/" + digestHex + "/link"
+}
+
+func testBlobPath(digestHex string) string {
+	return "blobs/sha256/" + digestHex[0:2] + "/" + digestHex + "/data"
+}
+
+func sortedPaths(paths ...string) []string {
+	sort.Strings(paths)
+	return paths
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, other := range paths {
+		if other == path {
+			return true
+		}
+	}
+	return false
+}
+
+// without returns paths without excluded ones
+func without(paths []string, excluded []string) []string {
+	var result []string
+	for _, path := range paths {
+		if !containsPath(excluded, path) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+func assertPaths(t *testing.T, name string, expected, paths []string) {
+	t.Helper()
+
+	if len(expected) == 0 && len(paths) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Fatalf("expected %s:\n%s\ngot:\n%s", name, strings.Join(expected, "\n"), strings.Join(paths, "\n"))
+	}
+}
+
+// testRepository creates group/app repository on filesystem storage of the test
+type testRepository struct {
+	*testManifests
+}
+
+func newTestRepository(t *testing.T) *testRepository {
+	startTestRunners()
+	return &testRepository{testManifests: newTestManifests(t)}
+}
+
+func (r *testRepository) link(path string, digestHex string) {
+	r.put(path, digestReferenceAlgorithm+digestHex)
+}
+
+// tag points latest tag to the manifest
+func (r *testRepository) tag(manifest string) {
+	r.link("repositories/group/app/_manifests/tags/latest/current/link", manifest)
+	r.link(testTagVersionPath(manifest), manifest)
+}
+
+func (r *testRepository) livePaths() []string {
+	root := r.storage.livePath("")
+
+	var paths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			paths = append(paths, strings.TrimPrefix(path, root+"/"))
+		}
+		return err
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return paths
+}
+
+// prune marks and sweeps repositories and blobs like Main does
+func (r *testRepository) prune() {
+	setTestFlag(r.t, &runStartedAt, time.Now())
+
+	index, err := newBlobIndex()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	defer index.close()
+	blobs := blobsData{index: index}
+
+	repositories, err := newRepositoriesData()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	steps := []func() error{
+		func() error { return repositories.walk(false) },
+		func() error { return blobs.walk(false) },
+		func() error { return repositories.mark(blobs) },
+		repositories.sweep,
+		blobs.sweep,
+	}
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			r.t.Fatal(err)
+		}
+	}
+}
+
+// testPush describes manifest pushed to group/app repository and all paths it created
+type testPush struct {
+	manifest string
+	paths    []string
+}
+
+func (r *testRepository) pushImage(mediaType string, layers ...string) *testPush {
+	push := &testPush{}
+
+	var digests []string
+	for _, layer := range append([]string{"config of " + layers[0]}, layers...) {
+		digestHex := r.blob(layer)
+		r.link(testLayerPath(digestHex), digestHex)
+		digests = append(digests, digestHex)
+		push.paths = append(push.paths, testBlobPath(digestHex), testLayerPath(digestHex))
+	}
+
+	push.manifest = r.image(mediaType, digests[0], digests[1:]...)
+	r.link(testRevisionPath(push.manifest), push.manifest)
+	push.paths = append(push.paths, testBlobPath(push.manifest), testRevisionPath(push.manifest))
+	return push
+}
+
+func (r *testRepository) pushIndex(manifests ...*testPush) *testPush {
+	push := &testPush{}
+	for _, manifest := range manifests {
+		push.paths = append(push.paths, manifest.manifest)
+	}
+
+	push.manifest = r.index(push.paths...)
+	r.link(testRevisionPath(push.manifest), push.manifest)
+	push.paths = []string{testBlobPath(push.manifest), testRevisionPath(push.manifest)}
+	return push
+}
+
+func unusedPaths(pushes ...*testPush) []string {
+	var paths []string
+	for _, push := range pushes {
+		paths = append(paths, push.paths...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestRepositoryMarkManifestLists(t *testing.T) {
+	const dockerImage = "application/vnd.docker.distribution.manifest.v2+json"
+
+	tests := []struct {
+		name       string
+		softErrors bool
+		// push creates repository and returns paths that are no longer used
+		push func(r *testRepository) []string
+	}{
+		{
+			name: "image",
+			push: func(r *testRepository) []string {
+				image := r.pushImage(dockerImage, "image layer")
+				old := r.pushImage(dockerImage, "old layer")
+				r.tag(image.manifest)
+				return unusedPaths(old)
+			},
+		},
+		{
+			name: "index",
+			push: func(r *testRepository) []string {
+				amd64 := r.pushImage(mediaTypeOCIManifest, "amd64 layer", "shared layer")
+				arm64 := r.pushImage(dockerImage, "arm64 layer", "shared layer")
+				old := r.pushImage(mediaTypeOCIManifest, "old layer")
+				r.tag(r.pushIndex(amd64, arm64).manifest)
+				return unusedPaths(old)
+			},
+		},
+		{
+			name: "nested index",
+			push: func(r *testRepository) []string {
+				image := r.pushImage(mediaTypeOCIManifest, "image layer")
+				old := r.pushImage(mediaTypeOCIManifest, "old layer")
+				r.tag(r.pushIndex(r.pushIndex(image)).manifest)
+				return unusedPaths(old)
+			},
+		},
+		{
+			name: "child of unused index",
+			push: func(r *testRepository) []string {
+				shared := r.pushImage(mediaTypeOCIManifest, "shared layer")
+				old := r.pushImage(mediaTypeOCIManifest, "old layer")
+				index := r.pushIndex(shared)
+				oldIndex := r.pushIndex(shared, old)
+				r.tag(index.manifest)
+				return unusedPaths(old, oldIndex)
+			},
+		},
+		{
+			name:       "missing child",
+			softErrors: true,
+			push: func(r *testRepository) []string {
+				image := r.pushImage(mediaTypeOCIManifest, "image layer")
+				missing := r.pushImage(mediaTypeOCIManifest, "missing layer")
+				r.tag(r.pushIndex(image, missing).manifest)
+
+				// revision link of child is missing, so the child is not marked
+				err := os.Remove(r.storage.livePath(testRevisionPath(missing.manifest)))
+				if err != nil {
+					r.t.Fatal(err)
+				}
+				return without(unusedPaths(missing), []string{testRevisionPath(missing.manifest)})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRepository(t)
+			unused := test.push(r)
+			all := r.livePaths()
+
+			setTestFlag(t, delete, true)
+			setTestFlag(t, softDelete, false)
+			setTestFlag(t, softErrors, test.softErrors)
+			r.prune()
+
+			assertPaths(t, "live paths", without(all, unused), r.livePaths())
+		})
+	}
+}
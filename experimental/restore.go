@@ -0,0 +1,263 @@
+package experimental
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	restore           = flag.Bool("restore", false, "Restore soft-deleted data from backup, instead of pruning")
+	restoreRepository = flag.String("restore-repository", "", "Restore only data of this repository")
+	restoreDigest     = flag.String("restore-digest", "", "Restore only data of this digest")
+)
+
+var (
+	restoredObjects int32
+	restoredSize    int64
+)
+
+type restoreData struct {
+	// blobs that were soft-deleted, but are not yet selected for restore
+	blobs map[string]fileInfo
+	// digests that are referenced by restored repository links
+	referenced map[string]bool
+	lock       sync.Mutex
+}
+
+// pathDigest returns hex of the digest that path describes or links to
+func pathDigest(path string) string {
+	segments := strings.Split(path, "/")
+	for idx := 1; idx < len(segments); idx++ {
+		if segments[idx-1] != digestAlgorithm && (idx < 2 || segments[idx-2] != digestAlgorithm) {
+			continue
+		}
+
+		var d digest
+		if d.decode([]byte(segments[idx])) == nil {
+			return segments[idx]
+		}
+	}
+	return ""
+}
+
+func restoreFilter() (repositoryPrefix, digestHex string) {
+	if *restoreRepository != "" {
+		repositoryPrefix = filepath.Join("repositories", *restoreRepository) + "/"
+	}
+	digestHex = strings.TrimPrefix(*restoreDigest, digestReferenceAlgorithm)
+	return
+}
+
+func (r *restoreData) matches(path string) bool {
+	repositoryPrefix, digestHex := restoreFilter()
+
+	if repositoryPrefix != "" && !strings.HasPrefix(path, repositoryPrefix) {
+		return false
+	}
+
+	if digestHex != "" && pathDigest(path) != digestHex {
+		return false
+	}
+
+	return true
+}
+
+// storageExists lists directory of path, as storages can not stat single objects
+func storageExists(storage storageObject, path string) (bool, error) {
+	var exists bool
+
+	err := storage.List(filepath.Dir(path), func(listPath string, info fileInfo, err error) error {
+		if strings.TrimSuffix(listPath, "/") == filepath.Base(path) {
+			exists = true
+		}
+		return err
+	})
+	return exists, err
+}
+
+// restoreMove moves data out of backup, but never overwrites live data
+func restoreMove(storage, backup storageObject, path string) error {
+	if exclusive, ok := backup.(exclusiveStorage); ok {
+		return exclusive.MoveExclusive(filepath.Join("backup", path), path)
+	}
+
+	// Data pushed after the check and before the move is overwritten
+	exists, err := storageExists(storage, path)
+	if err != nil {
+		return err
+	} else if exists {
+		return &os.PathError{Op: "restore", Path: path, Err: os.ErrExist}
+	}
+
+	return backup.Move(filepath.Join("backup", path), path)
+}
+
+func (r *restoreData) restoreFile(jg *jobGroup, backup storageObject, path string, info fileInfo) {
+	jg.dispatch(func() error {
+		logrus.Infoln("RESTORE", path, info.size)
+
+		err := restoreMove(currentStorage, backup, path)
+		if os.IsExist(err) {
+			logrus.Warningln("RESTORE:", path, ": refusing to overwrite existing data")
+			if *softErrors {
+				return nil
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		atomic.AddInt32(&restoredObjects, 1)
+		atomic.AddInt64(&restoredSize, info.size)
+		return nil
+	})
+}
+
+func (r *restoreData) walk(jg *jobGroup, backup storageObject) error {
+	return backup.Walk("backup", "backup", func(path string, info fileInfo, err error) error {
+		isBlob := strings.HasPrefix(path, "blobs/")
+
+		if !r.matches(path) {
+			if isBlob {
+				r.lock.Lock()
+				r.blobs[pathDigest(path)] = info
+				r.lock.Unlock()
+			}
+			return nil
+		}
+
+		// restore also blobs that are linked from restored repository
+		if digestHex := pathDigest(path); !isBlob && digestHex != "" {
+			r.lock.Lock()
+			r.referenced[digestHex] = true
+			r.lock.Unlock()
+		}
+
+		r.restoreFile(jg, backup, path, info)
+		return nil
+	})
+}
+
+func (r *restoreData) restoreReferenced(jg *jobGroup, backup storageObject) {
+	for digestHex := range r.referenced {
+		info, ok := r.blobs[digestHex]
+		if !ok {
+			continue
+		}
+
+		var d digest
+		if d.decode([]byte(digestHex)) != nil {
+			continue
+		}
+
+		blob := blobData{name: d}
+		r.restoreFile(jg, backup, blob.path(), info)
+	}
+}
+
+func (r *restoreData) unexpireFile(jg *jobGroup, storage expiringStorage, path string, info fileInfo) {
+	jg.dispatch(func() error {
+		restored, err := storage.Unexpire(path)
+		if err != nil || !restored {
+			return err
+		}
+
+		logrus.Infoln("UNEXPIRE", path, info.size)
+		atomic.AddInt32(&restoredObjects, 1)
+		atomic.AddInt64(&restoredSize, info.size)
+		return nil
+	})
+}
+
+func (r *restoreData) walkExpired(jg *jobGroup, storage expiringStorage, rootPath string, filter func(path string) bool) error {
+	return storage.WalkExpired(rootPath, func(path string, info fileInfo, err error) error {
+		if !filter(path) {
+			return nil
+		}
+
+		if digestHex := pathDigest(path); !strings.HasPrefix(path, "blobs/") && digestHex != "" {
+			r.lock.Lock()
+			r.referenced[digestHex] = true
+			r.lock.Unlock()
+		}
+
+		r.unexpireFile(jg, storage, path, info)
+		return nil
+	})
+}
+
+func (r *restoreData) restoreExpired() error {
+	logrus.Infoln("Restoring EXPIRED...")
+
+	storage := currentStorage.(expiringStorage)
+	jg := jobsRunner.group()
+
+	err := r.walkExpired(jg, storage, "repositories", r.matches)
+	if err != nil {
+		return err
+	}
+
+	err = jg.finish()
+	if err != nil {
+		return err
+	}
+
+	// only deleted blobs are walked, their tags tell which ones were expired
+	jg = jobsRunner.group()
+
+	err = r.walkExpired(jg, storage, "blobs", func(path string) bool {
+		return r.matches(path) || r.referenced[pathDigest(path)]
+	})
+	if err != nil {
+		return err
+	}
+
+	return jg.finish()
+}
+
+func restoreBackup() error {
+	logrus.Infoln("Restoring BACKUP...")
+
+	r := &restoreData{
+		blobs:      make(map[string]fileInfo),
+		referenced: make(map[string]bool),
+	}
+
+	backup := currentStorage.Backup()
+	jg := jobsRunner.group()
+
+	err := r.walk(jg, backup)
+	if err != nil {
+		return err
+	}
+
+	err = jg.finish()
+	if err != nil {
+		return err
+	}
+
+	jg = jobsRunner.group()
+	r.restoreReferenced(jg, backup)
+	err = jg.finish()
+	if err != nil {
+		return err
+	}
+
+	if *softDeleteMode == softDeleteModeTag {
+		return r.restoreExpired()
+	}
+	return nil
+}
+
+func restoreInfo() {
+	logrus.Warningln("RESTORE INFO:", restoredObjects, "objects,",
+		humanize.Bytes(uint64(restoredSize)),
+	)
+}
@@ -0,0 +1,34 @@
+package experimental
+
+import (
+	"flag"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var (
+	s3BackupBucket       = flag.String("s3-backup-bucket", "", "S3 bucket to which soft-deleted data is moved, by default the registry bucket")
+	s3BackupStorageClass = flag.String("s3-backup-storage-class", "", "S3 storage class of soft-deleted data, by default the storage class of deleted object")
+)
+
+// bucket returns bucket of objects that storage operates on
+func (f *s3Storage) bucket() string {
+	if f.backup {
+		return f.backupBucket()
+	}
+	return f.Bucket
+}
+
+func (f *s3Storage) backupBucket() string {
+	if *s3BackupBucket != "" {
+		return *s3BackupBucket
+	}
+	return f.Bucket
+}
+
+func (f *s3Storage) backupStorageClass() *string {
+	if *s3BackupStorageClass == "" {
+		return nil
+	}
+	return aws.String(*s3BackupStorageClass)
+}
@@ -0,0 +1,232 @@
+package experimental
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const s3MaxParts = 10000
+
+var (
+	s3MultipartCopyThreshold = flag.Int64("s3-multipart-copy-threshold", 5*1024*1024*1024, "Copy S3 objects larger than this size in bytes with multipart copy")
+	s3MultipartCopyPartSize  = flag.Int64("s3-multipart-copy-part-size", 512*1024*1024, "Size in bytes of a single part of S3 multipart copy")
+	s3MultipartCopyJobs      = flag.Int("s3-multipart-copy-jobs", 8, "Number of concurrent part copies of a single S3 object")
+)
+
+func (f *s3Storage) copySource(key string) *string {
+	return aws.String("/" + f.bucket() + "/" + key)
+}
+
+func (f *s3Storage) multipartCopyThreshold() int64 {
+	if f.MultipartCopyThresholdSize > 0 {
+		return f.MultipartCopyThresholdSize
+	}
+	return *s3MultipartCopyThreshold
+}
+
+func (f *s3Storage) multipartCopyPartSize() int64 {
+	if f.MultipartCopyChunkSize > 0 {
+		return f.MultipartCopyChunkSize
+	}
+	return *s3MultipartCopyPartSize
+}
+
+func (f *s3Storage) multipartCopyJobs() int {
+	if f.MultipartCopyMaxConcurrency > 0 {
+		return f.MultipartCopyMaxConcurrency
+	}
+	return *s3MultipartCopyJobs
+}
+
+// copyEncryption uses encryption configured for registry, or the one of source object
+func (f *s3Storage) copyEncryption(head *s3.HeadObjectOutput) (*string, *string) {
+	if f.Encrypt {
+		return f.serverSideEncryption()
+	}
+	return head.ServerSideEncryption, head.SSEKMSKeyId
+}
+
+// copyStorageClass uses storage class configured for backup, or the one of source object,
+// S3 compatible storages not reporting it use the one configured for registry
+func (f *s3Storage) copyStorageClass(head *s3.HeadObjectOutput) *string {
+	if f.backupStorageClass() != nil {
+		if f.backup {
+			// Restored data goes back to the storage class of registry
+			return f.storageClass()
+		}
+		return f.backupStorageClass()
+	} else if head.StorageClass != nil {
+		return head.StorageClass
+	}
+	return f.storageClass()
+}
+
+// copy creates a copy of object in destination bucket with the same storage class, encryption and metadata
+func (f *s3Storage) copy(source, destinationBucket, destination string) error {
+	var head *s3.HeadObjectOutput
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	err := f.throttle.call(func() (err error) {
+		head, err = f.S3.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(f.bucket()),
+			Key:    aws.String(source),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if aws.Int64Value(head.ContentLength) > f.multipartCopyThreshold() {
+		return f.multipartCopy(source, destinationBucket, destination, head)
+	}
+
+	encryption, keyID := f.copyEncryption(head)
+
+	atomic.AddInt64(&f.expensiveApiCalls, 1)
+	return f.throttle.call(func() error {
+		_, err := f.S3.CopyObject(&s3.CopyObjectInput{
+			CopySource:           f.copySource(source),
+			Bucket:               aws.String(destinationBucket),
+			Key:                  aws.String(destination),
+			ACL:                  f.objectACL(),
+			StorageClass:         f.copyStorageClass(head),
+			ServerSideEncryption: encryption,
+			SSEKMSKeyId:          keyID,
+		})
+		return err
+	})
+}
+
+func (f *s3Storage) multipartCopy(source, destinationBucket, destination string, head *s3.HeadObjectOutput) error {
+	size := aws.Int64Value(head.ContentLength)
+	partSize := f.multipartCopyPartSize()
+	if size > partSize*s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+
+	encryption, keyID := f.copyEncryption(head)
+
+	var upload *s3.CreateMultipartUploadOutput
+
+	atomic.AddInt64(&f.expensiveApiCalls, 1)
+	err := f.throttle.call(func() (err error) {
+		upload, err = f.S3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:               aws.String(destinationBucket),
+			Key:                  aws.String(destination),
+			ACL:                  f.objectACL(),
+			CacheControl:         head.CacheControl,
+			ContentDisposition:   head.ContentDisposition,
+			ContentEncoding:      head.ContentEncoding,
+			ContentLanguage:      head.ContentLanguage,
+			ContentType:          head.ContentType,
+			Metadata:             head.Metadata,
+			StorageClass:         f.copyStorageClass(head),
+			ServerSideEncryption: encryption,
+			SSEKMSKeyId:          keyID,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	parts := make([]*s3.CompletedPart, (size+partSize-1)/partSize)
+	err = f.copyParts(source, destinationBucket, destination, upload.UploadId, parts, size, partSize)
+	if err != nil {
+		// Parts that were in progress when upload was aborted can still be stored, abort again
+		f.abortCopy(destinationBucket, destination, upload.UploadId)
+		return err
+	}
+
+	atomic.AddInt64(&f.expensiveApiCalls, 1)
+	return f.throttle.call(func() error {
+		_, err := f.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(destinationBucket),
+			Key:             aws.String(destination),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		return err
+	})
+}
+
+func (f *s3Storage) abortCopy(destinationBucket, destination string, uploadID *string) {
+	atomic.AddInt64(&f.freeApiCalls, 1)
+	f.throttle.call(func() error {
+		_, err := f.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(destinationBucket),
+			Key:      aws.String(destination),
+			UploadId: uploadID,
+		})
+		return err
+	})
+}
+
+// copyParts copies parts concurrently, on the first error no more parts are copied and upload is aborted
+func (f *s3Storage) copyParts(source, destinationBucket, destination string, uploadID *string, parts []*s3.CompletedPart, size, partSize int64) error {
+	var (
+		wg       sync.WaitGroup
+		stop     int32
+		firstErr error
+	)
+
+	limit := make(chan struct{}, f.multipartCopyJobs())
+
+	for idx := range parts {
+		idx := idx
+		start := int64(idx) * partSize
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+
+		limit <- struct{}{}
+		if atomic.LoadInt32(&stop) != 0 {
+			break
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			var resp *s3.UploadPartCopyOutput
+
+			atomic.AddInt64(&f.expensiveApiCalls, 1)
+			err := f.throttle.call(func() (err error) {
+				resp, err = f.S3.UploadPartCopy(&s3.UploadPartCopyInput{
+					Bucket:          aws.String(destinationBucket),
+					Key:             aws.String(destination),
+					CopySource:      f.copySource(source),
+					CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+					PartNumber:      aws.Int64(int64(idx + 1)),
+					UploadId:        uploadID,
+				})
+				return err
+			})
+
+			if err != nil {
+				if atomic.CompareAndSwapInt32(&stop, 0, 1) {
+					firstErr = err
+					f.abortCopy(destinationBucket, destination, uploadID)
+				}
+				return
+			}
+
+			parts[idx] = &s3.CompletedPart{
+				ETag:       resp.CopyPartResult.ETag,
+				PartNumber: aws.Int64(int64(idx + 1)),
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
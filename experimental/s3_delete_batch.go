@@ -0,0 +1,131 @@
+package experimental
+
+import (
+	"flag"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+var (
+	s3DeleteBatchSize   = flag.Int("s3-delete-batch-size", listMax, "Maximum number of S3 keys removed with a single request (1 disables batching)")
+	s3DeleteBatchLinger = flag.Duration("s3-delete-batch-linger", 50*time.Millisecond, "Time to wait for more S3 keys before sending incomplete delete batch")
+)
+
+type s3DeleteRequest struct {
+	key       string
+	versionID string
+	result    chan error
+}
+
+func (r *s3DeleteRequest) id() string {
+	return r.key + "\x00" + r.versionID
+}
+
+// s3DeleteBatcher collects keys deleted by concurrent jobs
+// and removes them with a single DeleteObjects call,
+// every job waits for the result of its own key
+type s3DeleteBatcher struct {
+	*s3Stats
+	throttle *s3Throttle
+	S3       s3iface.S3API
+	bucket   string
+	requests chan *s3DeleteRequest
+}
+
+func (b *s3DeleteBatcher) delete(key, versionID string) error {
+	request := &s3DeleteRequest{
+		key:       key,
+		versionID: versionID,
+		result:    make(chan error, 1),
+	}
+
+	b.requests <- request
+	return <-request.result
+}
+
+func (b *s3DeleteBatcher) run() {
+	for request := range b.requests {
+		batch := []*s3DeleteRequest{request}
+		linger := time.NewTimer(*s3DeleteBatchLinger)
+
+	collect:
+		for len(batch) < *s3DeleteBatchSize {
+			select {
+			case request := <-b.requests:
+				batch = append(batch, request)
+			case <-linger.C:
+				break collect
+			}
+		}
+
+		linger.Stop()
+		go b.flush(batch)
+	}
+}
+
+func (b *s3DeleteBatcher) flush(batch []*s3DeleteRequest) {
+	objects := make([]*s3.ObjectIdentifier, 0, len(batch))
+	for _, request := range batch {
+		object := &s3.ObjectIdentifier{Key: aws.String(request.key)}
+		if request.versionID != "" {
+			object.VersionId = aws.String(request.versionID)
+		}
+		objects = append(objects, object)
+	}
+
+	var resp *s3.DeleteObjectsOutput
+
+	atomic.AddInt64(&b.freeApiCalls, 1)
+	atomic.AddInt64(&b.deleteBatches, 1)
+	err := b.throttle.call(func() (err error) {
+		resp, err = b.S3.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(b.bucket),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		return err
+	})
+
+	errors := make(map[string]error)
+	if err == nil {
+		for _, keyErr := range resp.Errors {
+			id := aws.StringValue(keyErr.Key) + "\x00" + aws.StringValue(keyErr.VersionId)
+			errors[id] = awserr.New(aws.StringValue(keyErr.Code), aws.StringValue(keyErr.Message), nil)
+		}
+	}
+
+	for _, request := range batch {
+		if err != nil {
+			request.result <- err
+		} else {
+			request.result <- errors[request.id()]
+		}
+	}
+}
+
+func newS3DeleteBatcher(stats *s3Stats, throttle *s3Throttle, client s3iface.S3API, bucket string) *s3DeleteBatcher {
+	batcher := &s3DeleteBatcher{
+		s3Stats:  stats,
+		throttle: throttle,
+		S3:       client,
+		bucket:   bucket,
+		requests: make(chan *s3DeleteRequest, *s3DeleteBatchSize),
+	}
+	go batcher.run()
+	return batcher
+}
+
+func checkS3DeleteBatchSize() error {
+	if *s3DeleteBatchSize < 1 || *s3DeleteBatchSize > listMax {
+		return fmt.Errorf("s3-delete-batch-size must be between 1 and %d", listMax)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package experimental
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// testDeleteObjects records batches of DeleteObjects calls,
+// keys listed in keyErrors fail with their code, err fails whole call
+type testDeleteObjects struct {
+	s3iface.S3API
+	keyErrors map[string]string
+	err       error
+
+	lock    sync.Mutex
+	batches [][]string
+}
+
+func (f *testDeleteObjects) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var batch []string
+	output := &s3.DeleteObjectsOutput{}
+	for _, object := range input.Delete.Objects {
+		id := aws.StringValue(object.Key)
+		if object.VersionId != nil {
+			id += "@" + aws.StringValue(object.VersionId)
+		}
+		batch = append(batch, id)
+
+		if code, ok := f.keyErrors[id]; ok {
+			output.Errors = append(output.Errors, &s3.Error{
+				Key:       object.Key,
+				VersionId: object.VersionId,
+				Code:      aws.String(code),
+				Message:   aws.String("failed"),
+			})
+		}
+	}
+	f.batches = append(f.batches, batch)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return output, nil
+}
+
+func (f *testDeleteObjects) batchSizes() []int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var sizes []int
+	for _, batch := range f.batches {
+		sizes = append(sizes, len(batch))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	return sizes
+}
+
+func newTestDeleteBatcher(t *testing.T, client *testDeleteObjects, size int, linger time.Duration) *s3DeleteBatcher {
+	setTestFlag(t, s3DeleteBatchSize, size)
+	setTestFlag(t, s3DeleteBatchLinger, linger)
+	setTestFlag(t, s3MaxRetries, 0)
+
+	return newS3DeleteBatcher(&s3Stats{}, newS3Throttle(), client, "registry")
+}
+
+// deleteConcurrently deletes keys from separate jobs, returns their errors by key
+func deleteConcurrently(batcher *s3DeleteBatcher, keys []string) map[string]error {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	errors := make(map[string]error)
+
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+
+			err := batcher.delete(key, "")
+			lock.Lock()
+			errors[key] = err
+			lock.Unlock()
+		}(key)
+	}
+
+	wg.Wait()
+	return errors
+}
+
+func testKeys(n int) []string {
+	var keys []string
+	for i := 0; i < n; i++ {
+		keys = append(keys, fmt.Sprintf("docker/registry/v2/blobs/%03d", i))
+	}
+	return keys
+}
+
+func TestS3DeleteBatcherBatches(t *testing.T) {
+	client := &testDeleteObjects{}
+	batcher := newTestDeleteBatcher(t, client, 10, 100*time.Millisecond)
+
+	for key, err := range deleteConcurrently(batcher, testKeys(25)) {
+		if err != nil {
+			t.Fatal("delete:", key, err)
+		}
+	}
+
+	if sizes := client.batchSizes(); fmt.Sprint(sizes) != "[10 10 5]" {
+		t.Fatal("expected full batches and the rest, got:", sizes)
+	}
+	if batcher.deleteBatches != 3 {
+		t.Fatal("expected 3 delete batches, got:", batcher.deleteBatches)
+	}
+}
+
+func TestS3DeleteBatcherLinger(t *testing.T) {
+	client := &testDeleteObjects{}
+	linger := 50 * time.Millisecond
+	batcher := newTestDeleteBatcher(t, client, 10, linger)
+
+	startedAt := time.Now()
+	err := batcher.delete("docker/registry/v2/blobs/000", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if waited := time.Since(startedAt); waited < linger {
+		t.Fatal("expected incomplete batch to wait for more keys, waited:", waited)
+	}
+	if fmt.Sprint(client.batches) != "[[docker/registry/v2/blobs/000@v1]]" {
+		t.Fatal("expected single incomplete batch, got:", client.batches)
+	}
+}
+
+func TestS3DeleteBatcherErrors(t *testing.T) {
+	keys := testKeys(5)
+
+	t.Run("key errors", func(t *testing.T) {
+		client := &testDeleteObjects{keyErrors: map[string]string{keys[1]: "AccessDenied", keys[3]: "InternalError"}}
+		batcher := newTestDeleteBatcher(t, client, 10, 50*time.Millisecond)
+
+		errors := deleteConcurrently(batcher, keys)
+		for _, key := range keys {
+			code := client.keyErrors[key]
+			awsErr, _ := errors[key].(awserr.Error)
+			if code == "" && errors[key] != nil {
+				t.Fatal("expected key to be deleted:", key, errors[key])
+			} else if code != "" && (awsErr == nil || awsErr.Code() != code) {
+				t.Fatalf("expected %s error of %s, got: %v", code, key, errors[key])
+			}
+		}
+
+		if len(client.batches) != 1 {
+			t.Fatal("expected keys to be deleted in a single batch, got:", client.batches)
+		}
+	})
+
+	t.Run("version errors", func(t *testing.T) {
+		client := &testDeleteObjects{keyErrors: map[string]string{keys[0] + "@v2": "AccessDenied"}}
+		batcher := newTestDeleteBatcher(t, client, 1, time.Millisecond)
+
+		if err := batcher.delete(keys[0], "v1"); err != nil {
+			t.Fatal("expected other version to be deleted:", err)
+		}
+		if err := batcher.delete(keys[0], "v2"); err == nil {
+			t.Fatal("expected version to fail to be deleted")
+		}
+	})
+
+	t.Run("request error", func(t *testing.T) {
+		client := &testDeleteObjects{err: awserr.New("AccessDenied", "denied", nil)}
+		batcher := newTestDeleteBatcher(t, client, 10, 50*time.Millisecond)
+
+		for key, err := range deleteConcurrently(batcher, keys) {
+			if err != client.err {
+				t.Fatal("expected request error for every key:", key, err)
+			}
+		}
+	})
+}
+
+func TestS3DeleteFailureIsNotCounted(t *testing.T) {
+	layer := "repositories/group/app/_layers/sha256/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/link"
+	manifest := "repositories/group/app/_manifests/revisions/sha256/708519982eae159899e908639f5fa22d23d247ad923f6e6ad6128894c5d497a0/link"
+
+	client := &testDeleteObjects{keyErrors: map[string]string{"docker/registry/v2/" + manifest: "AccessDenied"}}
+	storage := &s3Storage{
+		distributionStorageS3: &distributionStorageS3{Bucket: "registry"},
+		s3Stats:               &s3Stats{},
+		deletes:               newTestDeleteBatcher(t, client, 10, 10*time.Millisecond),
+	}
+
+	planFile := filepath.Join(t.TempDir(), "plan.jsonl")
+	writer, err := newPlanWriter(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestFlag(t, &currentStorage, storageObject(storage))
+	setTestFlag(t, &currentPlanWriter, writer)
+	setTestFlag(t, delete, true)
+	setTestFlag(t, softDelete, false)
+	setTestFlag(t, &deletedLinks, 0)
+
+	err = deleteFile(layer, digestReferenceSize, "unreferenced layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteFile(manifest, digestReferenceSize, "unreferenced manifest")
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "AccessDenied" {
+		t.Fatal("expected delete error to be returned to the job, got:", err)
+	}
+
+	if deletedLinks != 1 {
+		t.Fatal("expected only deleted link to be counted, got:", deletedLinks)
+	}
+
+	err = writer.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := loadDeletionPlan(planFile)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(plan.entries) != 1 || plan.entries[newPlanEntry(layer, 0, "").key()] == nil {
+		t.Fatal("expected only deleted link in plan, got:", plan.entries)
+	}
+}
@@ -0,0 +1,273 @@
+package experimental
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	s3ExpiredTag      = "pruner-expired"
+	s3ExpiredTagValue = "true"
+	s3RunIDTag        = "pruner-run-id"
+
+	s3MaxTags = 10
+)
+
+func s3RunID() string {
+	return runStartedAt.UTC().Format("20060102T150405Z")
+}
+
+func isS3Expired(tags []*s3.Tag) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == s3ExpiredTag {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutS3ExpiredTags returns tags that are not set by pruner
+func withoutS3ExpiredTags(tags []*s3.Tag) []*s3.Tag {
+	var result []*s3.Tag
+	for _, tag := range tags {
+		switch aws.StringValue(tag.Key) {
+		case s3ExpiredTag, s3RunIDTag:
+		default:
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func (f *s3Storage) objectTags(key, versionID string) ([]*s3.Tag, error) {
+	input := &s3.GetObjectTaggingInput{
+		Bucket: aws.String(f.bucket()),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	var resp *s3.GetObjectTaggingOutput
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	err := f.throttle.call(func() (err error) {
+		resp, err = f.S3.GetObjectTagging(input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.TagSet, nil
+}
+
+func (f *s3Storage) putObjectTags(key, versionID string, tags []*s3.Tag) error {
+	if len(tags) == 0 {
+		input := &s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(f.bucket()),
+			Key:    aws.String(key),
+		}
+		if versionID != "" {
+			input.VersionId = aws.String(versionID)
+		}
+
+		atomic.AddInt64(&f.freeApiCalls, 1)
+		return f.throttle.call(func() error {
+			_, err := f.S3.DeleteObjectTagging(input)
+			return err
+		})
+	}
+
+	input := &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(f.bucket()),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: tags},
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	atomic.AddInt64(&f.expensiveApiCalls, 1)
+	return f.throttle.call(func() error {
+		_, err := f.S3.PutObjectTagging(input)
+		return err
+	})
+}
+
+func (f *s3Storage) tagExpired(key string) error {
+	// Object tagging replaces all tags, keep tags that are not ours
+	tags, err := f.objectTags(key, "")
+	if err != nil {
+		return err
+	}
+
+	tags = withoutS3ExpiredTags(tags)
+	if len(tags) >= s3MaxTags {
+		return fmt.Errorf("%s: object already has %d tags, no room for %s tag", key, len(tags), s3ExpiredTag)
+	}
+
+	tags = append(tags, &s3.Tag{Key: aws.String(s3ExpiredTag), Value: aws.String(s3ExpiredTagValue)})
+	// Run ID is only informative
+	if len(tags) < s3MaxTags {
+		tags = append(tags, &s3.Tag{Key: aws.String(s3RunIDTag), Value: aws.String(s3RunID())})
+	}
+	return f.putObjectTags(key, "", tags)
+}
+
+// Expire tags object to be removed by bucket lifecycle rule and deletes it,
+// so it is kept as noncurrent version hidden from registry
+func (f *s3Storage) Expire(path string) error {
+	err := f.tagExpired(f.fullPath(path))
+	if err != nil {
+		return err
+	}
+	return f.Delete(path)
+}
+
+// WalkExpired walks objects that could be expired: deleted objects which noncurrent versions are kept
+func (f *s3Storage) WalkExpired(path string, fn walkFunc) error {
+	baseDir := s3DirPath(f.fullPath(""))
+
+	return f.listDeletedObjects(s3DirPath(f.fullPath(path)), func(object *s3DeletedObject) error {
+		keyPath := strings.TrimPrefix(object.key, baseDir)
+		if len(object.versions) == 0 || keyPath == "" || strings.HasSuffix(keyPath, "/") {
+			return nil
+		}
+
+		// Versions of key are listed from the latest, describe key with the latest data
+		fi := fileInfo{
+			fullPath: object.key,
+			size:     object.versions[0].size,
+		}
+		return fn(keyPath, fi, nil)
+	})
+}
+
+// latestVersions returns the latest delete marker, if the key is deleted, and the latest version of the key
+func (f *s3Storage) latestVersions(key string) (marker, version *s3ObjectVersion, err error) {
+	var resp *s3.ListObjectVersionsOutput
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	err = f.throttle.call(func() (err error) {
+		resp, err = f.S3.ListObjectVersions(&s3.ListObjectVersionsInput{
+			Bucket:  aws.String(f.bucket()),
+			Prefix:  aws.String(key),
+			MaxKeys: aws.Int64(listMax),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var versions []*s3.ObjectVersion
+	for _, objectVersion := range resp.Versions {
+		if *objectVersion.Key == key {
+			versions = append(versions, objectVersion)
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return aws.TimeValue(versions[i].LastModified).After(aws.TimeValue(versions[j].LastModified))
+	})
+
+	if len(versions) > 0 {
+		version = &s3ObjectVersion{key: key, versionID: aws.StringValue(versions[0].VersionId), size: aws.Int64Value(versions[0].Size)}
+	}
+
+	for _, deleteMarker := range resp.DeleteMarkers {
+		if *deleteMarker.Key == key && aws.BoolValue(deleteMarker.IsLatest) {
+			marker = &s3ObjectVersion{key: key, versionID: aws.StringValue(deleteMarker.VersionId)}
+		}
+	}
+	return
+}
+
+// Unexpire removes expiration tags and undeletes object,
+// returns false if the object was not expired
+func (f *s3Storage) Unexpire(path string) (bool, error) {
+	marker, version, err := f.latestVersions(f.fullPath(path))
+	if err != nil {
+		return false, err
+	} else if marker == nil || version == nil {
+		return false, nil
+	}
+
+	tags, err := f.objectTags(version.key, version.versionID)
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else if !isS3Expired(tags) {
+		return false, nil
+	}
+
+	// Removing the delete marker makes the previous version current again
+	err = f.deleteVersion(*marker)
+	if err != nil {
+		return false, err
+	}
+
+	err = f.putObjectTags(version.key, version.versionID, withoutS3ExpiredTags(tags))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// hasS3ExpireRule looks for rule that removes noncurrent versions of expired objects
+func hasS3ExpireRule(rules []*s3.LifecycleRule) bool {
+	for _, rule := range rules {
+		if aws.StringValue(rule.Status) != s3.ExpirationStatusEnabled || rule.Filter == nil {
+			continue
+		}
+
+		expiration := rule.NoncurrentVersionExpiration
+		if expiration == nil || aws.Int64Value(expiration.NoncurrentDays) <= 0 {
+			continue
+		}
+
+		tags := []*s3.Tag{rule.Filter.Tag}
+		if rule.Filter.And != nil {
+			tags = rule.Filter.And.Tags
+		}
+
+		for _, tag := range tags {
+			if tag != nil && aws.StringValue(tag.Key) == s3ExpiredTag && aws.StringValue(tag.Value) == s3ExpiredTagValue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *s3Storage) checkExpireLifecycle() {
+	var resp *s3.GetBucketLifecycleConfigurationOutput
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	err := f.throttle.call(func() (err error) {
+		resp, err = f.S3.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+			Bucket: aws.String(f.bucket()),
+		})
+		return err
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchLifecycleConfiguration" {
+		resp, err = &s3.GetBucketLifecycleConfigurationOutput{}, nil
+	} else if err != nil {
+		logrus.Warningln("S3: failed to get bucket lifecycle configuration:", err)
+		return
+	}
+
+	if !hasS3ExpireRule(resp.Rules) {
+		logrus.Warningln("S3: bucket has no enabled lifecycle rule with NoncurrentVersionExpiration for tag",
+			s3ExpiredTag+"="+s3ExpiredTagValue+", expired objects will never be removed")
+	}
+}
@@ -0,0 +1,102 @@
+package experimental
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestHasS3ExpireRule(t *testing.T) {
+	expiredTag := &s3.Tag{Key: aws.String(s3ExpiredTag), Value: aws.String(s3ExpiredTagValue)}
+	otherTag := &s3.Tag{Key: aws.String("team"), Value: aws.String("registry")}
+	noncurrent := &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(30)}
+
+	tests := []struct {
+		name  string
+		rule  *s3.LifecycleRule
+		found bool
+	}{
+		{
+			name: "tag filter",
+			rule: &s3.LifecycleRule{
+				Status:                      aws.String(s3.ExpirationStatusEnabled),
+				Filter:                      &s3.LifecycleRuleFilter{Tag: expiredTag},
+				NoncurrentVersionExpiration: noncurrent,
+			},
+			found: true,
+		},
+		{
+			name: "and filter",
+			rule: &s3.LifecycleRule{
+				Status: aws.String(s3.ExpirationStatusEnabled),
+				Filter: &s3.LifecycleRuleFilter{And: &s3.LifecycleRuleAndOperator{
+					Prefix: aws.String("docker/"),
+					Tags:   []*s3.Tag{otherTag, expiredTag},
+				}},
+				NoncurrentVersionExpiration: noncurrent,
+			},
+			found: true,
+		},
+		{
+			name: "disabled",
+			rule: &s3.LifecycleRule{
+				Status:                      aws.String(s3.ExpirationStatusDisabled),
+				Filter:                      &s3.LifecycleRuleFilter{Tag: expiredTag},
+				NoncurrentVersionExpiration: noncurrent,
+			},
+		},
+		{
+			name: "only current versions expired",
+			rule: &s3.LifecycleRule{
+				Status:     aws.String(s3.ExpirationStatusEnabled),
+				Filter:     &s3.LifecycleRuleFilter{Tag: expiredTag},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(30)},
+			},
+		},
+		{
+			name: "other tag",
+			rule: &s3.LifecycleRule{
+				Status:                      aws.String(s3.ExpirationStatusEnabled),
+				Filter:                      &s3.LifecycleRuleFilter{Tag: otherTag},
+				NoncurrentVersionExpiration: noncurrent,
+			},
+		},
+		{
+			name: "no filter",
+			rule: &s3.LifecycleRule{
+				Status:                      aws.String(s3.ExpirationStatusEnabled),
+				NoncurrentVersionExpiration: noncurrent,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if found := hasS3ExpireRule([]*s3.LifecycleRule{test.rule}); found != test.found {
+				t.Fatalf("expected %v, got %v", test.found, found)
+			}
+		})
+	}
+}
+
+func TestWithoutS3ExpiredTags(t *testing.T) {
+	tags := []*s3.Tag{
+		{Key: aws.String("team"), Value: aws.String("registry")},
+		{Key: aws.String(s3ExpiredTag), Value: aws.String(s3ExpiredTagValue)},
+		{Key: aws.String(s3RunIDTag), Value: aws.String("20200101T000000Z")},
+	}
+
+	if !isS3Expired(tags) {
+		t.Fatal("expected tags to be expired")
+	}
+
+	result := withoutS3ExpiredTags(tags)
+	if len(result) != 1 || aws.StringValue(result[0].Key) != "team" {
+		t.Fatal("expected only team tag, got", result)
+	}
+
+	if isS3Expired(result) {
+		t.Fatal("expected tags not to be expired")
+	}
+}
@@ -0,0 +1,258 @@
+package experimental
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	s3HexAlphabet   = "0123456789abcdef"
+	s3AlnumAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+var s3ListShards = flag.Int("s3-list-shards", 1, "Number of key ranges of large S3 prefix listed concurrently")
+
+// s3ListRange lists keys after startAfter, up to and including until
+type s3ListRange struct {
+	startAfter string
+	until      string
+}
+
+func (f *s3Storage) listObjectsV2(input *s3.ListObjectsV2Input) (resp *s3.ListObjectsV2Output, err error) {
+	atomic.AddInt64(&f.apiCalls, 1)
+	err = f.throttle.call(func() error {
+		resp, err = f.S3.ListObjectsV2(input)
+		return err
+	})
+	return
+}
+
+func s3DirPath(path string) string {
+	if path != "/" && path[len(path)-1] != '/' {
+		path = path + "/"
+	}
+	return path
+}
+
+// s3ShardAlphabet returns prefix of keys and characters that follow it,
+// blob keys are spread evenly by digest, other keys mostly by names
+func s3ShardAlphabet(path string) (string, string) {
+	if strings.HasSuffix(path, "/blobs/") {
+		return path + "sha256/", s3HexAlphabet
+	} else if strings.HasSuffix(path, "/blobs/sha256/") {
+		return path, s3HexAlphabet
+	}
+	return path, s3AlnumAlphabet
+}
+
+// s3ListRanges splits keys of path following lastKey into ranges,
+// ranges are split by the first two characters following path
+func s3ListRanges(path, lastKey string, shards int) []s3ListRange {
+	prefix, alphabet := s3ShardAlphabet(path)
+	keyspace := len(alphabet) * len(alphabet)
+	if shards > keyspace {
+		shards = keyspace
+	}
+
+	var ranges []s3ListRange
+	startAfter := lastKey
+
+	for shard := 1; shard <= shards; shard++ {
+		until := ""
+		if shard < shards {
+			idx := shard * keyspace / shards
+			until = prefix + string(alphabet[idx/len(alphabet)]) + string(alphabet[idx%len(alphabet)])
+			if until <= startAfter {
+				continue
+			}
+		}
+
+		ranges = append(ranges, s3ListRange{startAfter: startAfter, until: until})
+		startAfter = until
+	}
+	return ranges
+}
+
+// listRange lists keys of the range, returns last listed key and if there are more keys to list
+func (f *s3Storage) listRange(path string, listRange s3ListRange, onePage bool, stop *int32, fn func(key *s3.Object) error) (string, bool, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(f.bucket()),
+		Prefix:  aws.String(path),
+		MaxKeys: aws.Int64(listMax),
+	}
+	if listRange.startAfter != "" {
+		input.StartAfter = aws.String(listRange.startAfter)
+	}
+
+	lastKey := listRange.startAfter
+
+	for atomic.LoadInt32(stop) == 0 {
+		resp, err := f.listObjectsV2(input)
+		if err != nil {
+			return lastKey, false, err
+		}
+
+		for _, key := range resp.Contents {
+			if listRange.until != "" && *key.Key > listRange.until {
+				return lastKey, false, nil
+			}
+
+			lastKey = *key.Key
+			err = fn(key)
+			if err != nil {
+				return lastKey, false, err
+			}
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return lastKey, false, nil
+		} else if onePage {
+			return lastKey, true, nil
+		}
+
+		input.StartAfter = nil
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+
+	return lastKey, false, nil
+}
+
+func (f *s3Storage) Walk(path string, baseDir string, fn walkFunc) error {
+	path = s3DirPath(f.fullPath(path))
+	baseDir = s3DirPath(f.fullPath(baseDir))
+
+	var (
+		lock sync.Mutex
+		stop int32
+	)
+
+	walkFn := func(key *s3.Object) error {
+		keyPath := *key.Key
+		if strings.HasPrefix(keyPath, baseDir) {
+			keyPath = keyPath[len(baseDir):]
+		}
+
+		if keyPath == "" {
+			return nil
+		}
+
+		if strings.HasSuffix(keyPath, "/") {
+			logrus.Debugln("S3 Walk:", keyPath, "for", baseDir)
+			return nil
+		}
+
+		fi := fileInfo{
+			fullPath:     *key.Key,
+			size:         *key.Size,
+			etag:         *key.ETag,
+			lastModified: *key.LastModified,
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		return fn(keyPath, fi, nil)
+	}
+
+	// The first page is listed serially, to not split small prefixes
+	lastKey, truncated, err := f.listRange(path, s3ListRange{}, *s3ListShards > 1, &stop, walkFn)
+	if err != nil || !truncated {
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		firstErr error
+	)
+
+	for _, listRange_ := range s3ListRanges(path, lastKey, *s3ListShards) {
+		listRange := listRange_
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, _, err := f.listRange(path, listRange, false, &stop, walkFn)
+			if err != nil && atomic.CompareAndSwapInt32(&stop, 0, 1) {
+				firstErr = err
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+func (f *s3Storage) List(path string, fn walkFunc) error {
+	path = s3DirPath(f.fullPath(path))
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(f.bucket()),
+		Prefix:    aws.String(path),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(listMax),
+	}
+
+	for {
+		resp, err := f.listObjectsV2(input)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range resp.Contents {
+			keyPath := *key.Key
+			if strings.HasPrefix(keyPath, path) {
+				keyPath = keyPath[len(path):]
+			}
+
+			if keyPath == "" {
+				continue
+			}
+
+			fi := fileInfo{
+				fullPath:     *key.Key,
+				size:         *key.Size,
+				etag:         *key.ETag,
+				lastModified: *key.LastModified,
+				directory:    strings.HasSuffix(*key.Key, "/"),
+			}
+
+			err = fn(keyPath, fi, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, commonPrefix := range resp.CommonPrefixes {
+			prefixPath := *commonPrefix.Prefix
+			if strings.HasPrefix(prefixPath, path) {
+				prefixPath = prefixPath[len(path):]
+			}
+
+			if prefixPath == "" {
+				continue
+			}
+
+			fi := fileInfo{
+				fullPath:  *commonPrefix.Prefix,
+				directory: true,
+			}
+
+			err = fn(prefixPath, fi, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+}
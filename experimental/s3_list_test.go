@@ -0,0 +1,111 @@
+package experimental
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestS3ShardAlphabet(t *testing.T) {
+	tests := []struct {
+		path     string
+		prefix   string
+		alphabet string
+	}{
+		{"docker/registry/v2/blobs/", "docker/registry/v2/blobs/sha256/", s3HexAlphabet},
+		{"docker/registry/v2/blobs/sha256/", "docker/registry/v2/blobs/sha256/", s3HexAlphabet},
+		{"docker/registry/v2/repositories/", "docker/registry/v2/repositories/", s3AlnumAlphabet},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			prefix, alphabet := s3ShardAlphabet(test.path)
+			if prefix != test.prefix || alphabet != test.alphabet {
+				t.Fatalf("expected %q and %q, got %q and %q", test.prefix, test.alphabet, prefix, alphabet)
+			}
+		})
+	}
+}
+
+// testS3ListKeys returns keys spread over the whole keyspace of path
+func testS3ListKeys(path string) []string {
+	prefix, alphabet := s3ShardAlphabet(path)
+
+	var keys []string
+	for _, first := range alphabet {
+		for _, second := range alphabet {
+			keys = append(keys,
+				prefix+string(first)+string(second),
+				prefix+string(first)+string(second)+"/data",
+				prefix+string(first)+string(second)+strings.Repeat("z", 64))
+		}
+	}
+	return keys
+}
+
+func TestS3ListRanges(t *testing.T) {
+	paths := []string{"docker/registry/v2/blobs/", "docker/registry/v2/repositories/"}
+
+	for _, path := range paths {
+		prefix, _ := s3ShardAlphabet(path)
+		lastKeys := []string{path, prefix + "00/data", prefix + "7f", prefix + "c3/data", prefix + "zz/data"}
+
+		for _, lastKey := range lastKeys {
+			for _, shards := range []int{1, 2, 7, 16, 256, 2000} {
+				t.Run(fmt.Sprint(path, lastKey, "/", shards), func(t *testing.T) {
+					ranges := s3ListRanges(path, lastKey, shards)
+					if len(ranges) == 0 || len(ranges) > shards {
+						t.Fatalf("expected at most %d ranges, got %d", shards, len(ranges))
+					}
+
+					// ranges are contiguous, start after last listed key and end with the rest of keys
+					for idx, listRange := range ranges {
+						startAfter := lastKey
+						if idx > 0 {
+							startAfter = ranges[idx-1].until
+						}
+
+						if listRange.startAfter != startAfter {
+							t.Fatalf("expected range %d to start after %q, got %+v", idx, startAfter, listRange)
+						} else if idx == len(ranges)-1 && listRange.until != "" {
+							t.Fatalf("expected last range to be open, got %+v", listRange)
+						} else if idx < len(ranges)-1 && listRange.until <= listRange.startAfter {
+							t.Fatalf("expected range %d not to be empty, got %+v", idx, listRange)
+						}
+					}
+
+					// every key following last listed key is in exactly one range
+					for _, key := range testS3ListKeys(path) {
+						var found int
+						for _, listRange := range ranges {
+							if key > listRange.startAfter && (listRange.until == "" || key <= listRange.until) {
+								found++
+							}
+						}
+
+						if key <= lastKey && found != 0 {
+							t.Fatalf("expected listed key %q not to be in ranges", key)
+						} else if key > lastKey && found != 1 {
+							t.Fatalf("expected key %q in exactly one range, found in %d: %+v", key, found, ranges)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestS3ListRangesSplitKeyspaceEvenly(t *testing.T) {
+	prefix, alphabet := s3ShardAlphabet("docker/registry/v2/blobs/")
+	ranges := s3ListRanges("docker/registry/v2/blobs/", "", 16)
+	if len(ranges) != 16 {
+		t.Fatal("expected 16 ranges, got:", len(ranges))
+	}
+
+	for idx, listRange := range ranges[:15] {
+		expected := prefix + string(alphabet[idx+1]) + "0"
+		if listRange.until != expected {
+			t.Fatalf("expected range %d until %q, got %q", idx, expected, listRange.until)
+		}
+	}
+}
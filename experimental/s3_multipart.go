@@ -0,0 +1,175 @@
+package experimental
+
+import (
+	"flag"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dustin/go-humanize"
+)
+
+var deleteOldMultipartUploads = flag.Duration("delete-old-multipart-uploads", 0, "Abort incomplete S3 multipart uploads started longer ago than this duration (0 keeps all multipart uploads)")
+
+var (
+	multipartUploads        int32
+	multipartUploadParts    int32
+	multipartUploadSize     int64
+	abortedMultipartUploads int32
+	abortedMultipartSize    int64
+)
+
+func (f *s3Storage) listMultipartUploads(prefix string, fn func(upload *s3.MultipartUpload) error) error {
+	input := &s3.ListMultipartUploadsInput{
+		Bucket:     aws.String(f.bucket()),
+		Prefix:     aws.String(prefix),
+		MaxUploads: aws.Int64(listMax),
+	}
+
+	for {
+		var resp *s3.ListMultipartUploadsOutput
+
+		atomic.AddInt64(&f.apiCalls, 1)
+		err := f.throttle.call(func() (err error) {
+			resp, err = f.S3.ListMultipartUploads(input)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, upload := range resp.Uploads {
+			err = fn(upload)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return nil
+		}
+
+		input.KeyMarker = resp.NextKeyMarker
+		input.UploadIdMarker = resp.NextUploadIdMarker
+	}
+}
+
+func (f *s3Storage) multipartUploadParts(upload *s3.MultipartUpload) (int, int64, error) {
+	var parts int
+	var size int64
+
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(f.bucket()),
+		Key:      upload.Key,
+		UploadId: upload.UploadId,
+		MaxParts: aws.Int64(listMax),
+	}
+
+	for {
+		var resp *s3.ListPartsOutput
+
+		atomic.AddInt64(&f.apiCalls, 1)
+		err := f.throttle.call(func() (err error) {
+			resp, err = f.S3.ListParts(input)
+			return err
+		})
+		if err != nil {
+			return parts, size, err
+		}
+
+		for _, part := range resp.Parts {
+			parts++
+			size += aws.Int64Value(part.Size)
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return parts, size, nil
+		}
+
+		input.PartNumberMarker = resp.NextPartNumberMarker
+	}
+}
+
+func (f *s3Storage) abortMultipartUpload(upload *s3.MultipartUpload) error {
+	atomic.AddInt64(&f.freeApiCalls, 1)
+	return f.throttle.call(func() error {
+		_, err := f.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(f.bucket()),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		return err
+	})
+}
+
+func (f *s3Storage) sweepMultipartUploads(maxAge time.Duration) error {
+	baseDir := s3DirPath(f.fullPath(""))
+	jg := deletesRunner.group()
+
+	err := f.listMultipartUploads(baseDir, func(upload *s3.MultipartUpload) error {
+		jg.dispatch(func() error {
+			parts, size, err := f.multipartUploadParts(upload)
+			if err != nil {
+				return err
+			}
+
+			atomic.AddInt32(&multipartUploads, 1)
+			atomic.AddInt32(&multipartUploadParts, int32(parts))
+			atomic.AddInt64(&multipartUploadSize, size)
+
+			age := runStartedAt.Sub(aws.TimeValue(upload.Initiated))
+			logrus.Infoln("MULTIPART UPLOAD:", *upload.Key, ":", parts, "parts,", humanize.Bytes(uint64(size)), ": started", age, "ago")
+
+			if age <= maxAge {
+				return nil
+			}
+
+			entry := newPlanEntry(strings.TrimPrefix(*upload.Key, baseDir), size, "multipart upload started "+age.String()+" ago")
+			entry.Kind = "multipart-upload"
+			entry.ID = aws.StringValue(upload.UploadId)
+			if !isPlanned(entry) {
+				return nil
+			}
+
+			logrus.Infoln("ABORT", *upload.Key, entry.ID, size)
+
+			err = deletePlanned(entry, func() error {
+				return f.abortMultipartUpload(upload)
+			})
+			if err != nil {
+				return err
+			}
+
+			atomic.AddInt32(&abortedMultipartUploads, 1)
+			atomic.AddInt64(&abortedMultipartSize, size)
+			return nil
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return jg.finish()
+}
+
+func sweepMultipartUploads(maxAge time.Duration) error {
+	storage, ok := currentStorage.(*s3Storage)
+	if !ok {
+		logrus.Warningln("Multipart uploads are supported only on S3 storage")
+		return nil
+	}
+	return storage.sweepMultipartUploads(maxAge)
+}
+
+func multipartUploadsInfo() {
+	logrus.Warningln("MULTIPART UPLOADS INFO:", multipartUploads, "uploads,",
+		multipartUploadParts, "parts,",
+		humanize.Bytes(uint64(multipartUploadSize)), "/",
+		abortedMultipartUploads, "aborted,",
+		humanize.Bytes(uint64(abortedMultipartSize)),
+	)
+}
@@ -0,0 +1,111 @@
+package experimental
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestS3SweepMultipartUploads(t *testing.T) {
+	const day = 24 * time.Hour
+	startedAt := time.Now().Truncate(time.Second)
+
+	initiated := map[string]time.Time{
+		"old":      startedAt.Add(-10 * day),
+		"boundary": startedAt.Add(-7 * day),
+		"recent":   startedAt.Add(-time.Hour),
+	}
+
+	var lock sync.Mutex
+	var aborted []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		switch {
+		case r.Method == http.MethodGet && query.Has("uploads"):
+			fmt.Fprint(w, `<ListMultipartUploadsResult><Bucket>registry</Bucket><IsTruncated>false</IsTruncated>`)
+			for id, at := range initiated {
+				fmt.Fprintf(w, `<Upload><Key>docker/registry/v2/blobs/%s/data</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>`,
+					id, id, at.UTC().Format(time.RFC3339))
+			}
+			fmt.Fprint(w, `</ListMultipartUploadsResult>`)
+
+		case r.Method == http.MethodGet && query.Has("uploadId"):
+			fmt.Fprint(w, `<ListPartsResult><IsTruncated>false</IsTruncated>`+
+				`<Part><PartNumber>1</PartNumber><Size>10</Size></Part><Part><PartNumber>2</PartNumber><Size>5</Size></Part>`+
+				`</ListPartsResult>`)
+
+		case r.Method == http.MethodDelete && query.Has("uploadId"):
+			lock.Lock()
+			aborted = append(aborted, query.Get("uploadId"))
+			lock.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	startTestRunners()
+	setTestFlag(t, s3MaxRetries, 0)
+	setTestFlag(t, s3DeleteBatchSize, 1)
+	setTestFlag(t, &runStartedAt, startedAt)
+	setTestFlag(t, delete, true)
+	setTestFlag(t, &abortedMultipartUploads, 0)
+	setTestFlag(t, &abortedMultipartSize, 0)
+
+	storage, err := newS3Storage(&distributionStorageS3{
+		AccessKey:      "access",
+		SecretKey:      "secret",
+		Bucket:         "registry",
+		Region:         aws.String("us-east-1"),
+		RegionEndpoint: aws.String(server.URL),
+		ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	planFile := filepath.Join(t.TempDir(), "plan.jsonl")
+	writer, err := newPlanWriter(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestFlag(t, &currentPlanWriter, writer)
+
+	err = storage.(*s3Storage).sweepMultipartUploads(7 * day)
+	if err == nil {
+		err = writer.close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(aborted)
+	if strings.Join(aborted, ",") != "old" {
+		t.Fatal("expected only upload started before cutoff to be aborted, got:", aborted)
+	} else if abortedMultipartUploads != 1 || abortedMultipartSize != 15 {
+		t.Fatalf("expected aborted upload of 15 bytes to be counted, got %d, %d", abortedMultipartUploads, abortedMultipartSize)
+	}
+
+	plan, err := loadDeletionPlan(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	planned := newPlanEntry("blobs/old/data", 0, "")
+	planned.ID = "old"
+	if entry := plan.entries[planned.key()]; len(plan.entries) != 1 || entry == nil || entry.Kind != "multipart-upload" || entry.Size != 15 {
+		t.Fatal("expected aborted upload in plan, got:", plan.entries)
+	}
+}
@@ -1,16 +1,19 @@
 package experimental
 
 import (
-	"flag"
+	"crypto/tls"
+	"errors"
 	"io/ioutil"
-	"os"
+	"net/http"
 	"path/filepath"
-	"strings"
 	"sync/atomic"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
@@ -19,251 +22,183 @@ const listMax = 1000
 
 type s3Storage struct {
 	*distributionStorageS3
-	S3                *s3.S3
+	*s3Stats
+	S3       *s3.S3
+	throttle *s3Throttle
+	deletes  *s3DeleteBatcher
+	backup   bool
+
+	// backupDeletes removes keys of backup bucket, when it is not the registry bucket
+	backupDeletes *s3DeleteBatcher
+
+	versioned bool
+
+	// deletedKeys are keys deleted by the run, which versions are purged
+	deletedKeys *s3KeySet
+}
+
+type s3Stats struct {
+	storageCache
 	apiCalls          int64
 	expensiveApiCalls int64
 	freeApiCalls      int64
-	cacheHits         int64
-	cacheError        int64
-	cacheMiss         int64
+	deleteBatches     int64
 }
 
-var s3CacheStorage = flag.String("s3-storage-cache", "tmp-cache", "s3 cache")
-
 func (f *s3Storage) fullPath(path string) string {
+	if f.backup {
+		return f.backupPath(path)
+	}
+	return f.livePath(path)
+}
+
+func (f *s3Storage) livePath(path string) string {
 	return filepath.Join(f.RootDirectory, "docker", "registry", "v2", path)
 }
 
+// backupPath is built the same way as livePath, so backups of earlier runs keep their keys
 func (f *s3Storage) backupPath(path string) string {
-	return filepath.Join(f.RootDirectory, "docker-backup", "registry", "v2", path)
+	return filepath.Join(backupRoot(f.RootDirectory), "docker-backup", "registry", "v2", path)
 }
 
-func (f *s3Storage) Walk(path string, baseDir string, fn walkFunc) error {
-	path = f.fullPath(path)
-	if path != "/" && path[len(path)-1] != '/' {
-		path = path + "/"
+func (f *s3Storage) Read(path string, etag string) ([]byte, error) {
+	if data := f.readCache(path, etag); data != nil {
+		return data, nil
 	}
 
-	baseDir = f.fullPath(baseDir)
-	if baseDir != "/" && baseDir[len(baseDir)-1] != '/' {
-		baseDir = baseDir + "/"
-	}
+	var data []byte
 
 	atomic.AddInt64(&f.apiCalls, 1)
-	resp, err := f.S3.ListObjects(&s3.ListObjectsInput{
-		Bucket:  aws.String(f.Bucket),
-		Prefix:  aws.String(path),
-		MaxKeys: aws.Int64(listMax),
+	err := f.throttle.call(func() error {
+		resp, err := f.S3.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(f.bucket()),
+			Key:    aws.String(f.fullPath(path)),
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		data, err = ioutil.ReadAll(resp.Body)
+		return err
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for {
-		lastKey := ""
-
-		for _, key := range resp.Contents {
-			lastKey = *key.Key
-			keyPath := *key.Key
-			if strings.HasPrefix(keyPath, baseDir) {
-				keyPath = keyPath[len(baseDir):]
-			}
-
-			if keyPath == "" {
-				continue
-			}
-
-			if strings.HasSuffix(keyPath, "/") {
-				logrus.Debugln("S3 Walk:", keyPath, "for", baseDir)
-				continue
-			}
-
-			fi := fileInfo{
-				fullPath:     *key.Key,
-				size:         *key.Size,
-				etag:         *key.ETag,
-				lastModified: *key.LastModified,
-			}
-			err = fn(keyPath, fi, err)
-			if err != nil {
-				return err
-			}
-		}
+	f.writeCache(path, etag, data)
+	return data, nil
+}
 
-		if *resp.IsTruncated {
-			atomic.AddInt64(&f.apiCalls, 1)
-			resp, err = f.S3.ListObjects(&s3.ListObjectsInput{
-				Bucket:  aws.String(f.Bucket),
-				Prefix:  aws.String(path),
-				MaxKeys: aws.Int64(listMax),
-				Marker:  aws.String(lastKey),
-			})
-			if err != nil {
-				return err
-			}
-		} else {
-			break
-		}
+func (f *s3Storage) Delete(path string) error {
+	if f.versioned && *s3PurgeVersions {
+		f.deletedKeys.add(f.fullPath(path))
 	}
 
-	return nil
+	if f.deletes != nil {
+		return f.deletes.delete(f.fullPath(path), "")
+	}
+
+	atomic.AddInt64(&f.freeApiCalls, 1)
+	return f.throttle.call(func() error {
+		_, err := f.S3.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(f.bucket()),
+			Key:    aws.String(f.fullPath(path)),
+		})
+		return err
+	})
 }
 
-func (f *s3Storage) List(path string, fn walkFunc) error {
-	path = f.fullPath(path)
-	if path != "/" && path[len(path)-1] != '/' {
-		path = path + "/"
+func (f *s3Storage) Move(path, newPath string) error {
+	var newBucket string
+
+	// Moving out of backup restores data
+	if f.backup {
+		newBucket = f.Bucket
+		newPath = f.livePath(newPath)
+	} else {
+		newBucket = f.backupBucket()
+		newPath = f.backupPath(newPath)
 	}
 
-	atomic.AddInt64(&f.apiCalls, 1)
-	resp, err := f.S3.ListObjects(&s3.ListObjectsInput{
-		Bucket:    aws.String(f.Bucket),
-		Prefix:    aws.String(path),
-		Delimiter: aws.String("/"),
-		MaxKeys:   aws.Int64(listMax),
-	})
+	err := f.copy(f.fullPath(path), newBucket, newPath)
 	if err != nil {
 		return err
 	}
+	return f.Delete(path)
+}
 
-	for {
-		for _, key := range resp.Contents {
-			keyPath := *key.Key
-			if strings.HasPrefix(keyPath, path) {
-				keyPath = keyPath[len(path):]
-			}
-
-			if keyPath == "" {
-				continue
-			}
-
-			fi := fileInfo{
-				fullPath:     *key.Key,
-				size:         *key.Size,
-				etag:         *key.ETag,
-				lastModified: *key.LastModified,
-				directory:    strings.HasSuffix(*key.Key, "/"),
-			}
-
-			err = fn(keyPath, fi, err)
-			if err != nil {
-				return err
-			}
-		}
-
-		for _, commonPrefix := range resp.CommonPrefixes {
-			prefixPath := *commonPrefix.Prefix
-			if strings.HasPrefix(prefixPath, path) {
-				prefixPath = prefixPath[len(path):]
-			}
-
-			if prefixPath == "" {
-				continue
-			}
-
-			fi := fileInfo{
-				fullPath:  *commonPrefix.Prefix,
-				directory: true,
-			}
-
-			err = fn(prefixPath, fi, err)
-			if err != nil {
-				return err
-			}
-		}
-
-		if *resp.IsTruncated {
-			atomic.AddInt64(&f.apiCalls, 1)
-			resp, err = f.S3.ListObjects(&s3.ListObjectsInput{
-				Bucket:    aws.String(f.Bucket),
-				Prefix:    aws.String(path),
-				MaxKeys:   aws.Int64(listMax),
-				Delimiter: aws.String("/"),
-				Marker:    resp.NextMarker,
-			})
-			if err != nil {
-				return err
-			}
-		} else {
-			break
-		}
+func (f *s3Storage) Backup() storageObject {
+	backup := *f
+	backup.backup = true
+	if f.backupDeletes != nil {
+		backup.deletes = f.backupDeletes
 	}
+	return &backup
+}
 
-	return nil
+func (f *s3Storage) Info() {
+	logrus.Infoln("S3 INFO: API calls/expensive/free:", f.apiCalls, f.expensiveApiCalls, f.freeApiCalls,
+		"Delete batches:", f.deleteBatches,
+		"Retries/throttles/concurrency:", f.throttle.retries, f.throttle.throttles, f.throttle.currentConcurrency(),
+		"Cache (hit/miss/error):", f.cacheHits, f.cacheMiss, f.cacheError)
 }
 
-func (f *s3Storage) Read(path string, etag string) ([]byte, error) {
-	cachePath := filepath.Join(*s3CacheStorage, path)
-	if etag != "" && *s3CacheStorage != "" {
-		file, err := ioutil.ReadFile(cachePath)
-		if err == nil {
-			if compareEtag(file, etag) {
-				atomic.AddInt64(&f.cacheHits, 1)
-				return file, nil
-			} else {
-				atomic.AddInt64(&f.cacheError, 1)
-			}
-		} else if os.IsNotExist(err) {
-			atomic.AddInt64(&f.cacheMiss, 1)
-			logrus.Infoln("CACHE MISS:", path)
-		}
+// serverSideEncryption returns encryption that registry uses for new objects
+func (c *distributionStorageS3) serverSideEncryption() (*string, *string) {
+	if !c.Encrypt {
+		return nil, nil
+	} else if c.KeyID != "" {
+		return aws.String(s3.ServerSideEncryptionAwsKms), aws.String(c.KeyID)
 	}
+	return aws.String(s3.ServerSideEncryptionAes256), nil
+}
 
-	atomic.AddInt64(&f.apiCalls, 1)
-	resp, err := f.S3.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(f.Bucket),
-		Key:    aws.String(f.fullPath(path)),
-	})
-
-	if err != nil {
-		return nil, err
+func (c *distributionStorageS3) storageClass() *string {
+	if c.StorageClass == "" || c.StorageClass == "NONE" {
+		return nil
 	}
-	defer resp.Body.Close()
+	return aws.String(c.StorageClass)
+}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+func (c *distributionStorageS3) objectACL() *string {
+	if c.ObjectACL == "" {
+		return nil
 	}
+	return aws.String(c.ObjectACL)
+}
 
-	if etag != "" && *s3CacheStorage != "" {
-		os.MkdirAll(filepath.Dir(cachePath), 0700)
-		ioutil.WriteFile(cachePath, data, 0600)
+func (c *distributionStorageS3) credentials(awsConfig *aws.Config) *credentials.Credentials {
+	if c.AccessKey != "" && c.SecretKey != "" {
+		return credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, c.SessionToken)
+	} else if c.CredentialsEndpoint != "" {
+		endpointConfig := defaults.Config()
+		if awsConfig.HTTPClient != nil {
+			endpointConfig.HTTPClient = awsConfig.HTTPClient
+		}
+		return endpointcreds.NewCredentialsClient(*endpointConfig, defaults.Handlers(), c.CredentialsEndpoint)
 	}
 
-	return data, nil
-}
-
-func (f *s3Storage) Delete(path string) error {
-	atomic.AddInt64(&f.freeApiCalls, 1)
-	_, err := f.S3.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(f.Bucket),
-		Key:    aws.String(f.fullPath(path)),
-	})
-	return err
+	// Use default credential chain: environment, shared credentials and instance role
+	return nil
 }
 
-func (f *s3Storage) Move(path, newPath string) error {
-	atomic.AddInt64(&f.expensiveApiCalls, 1)
-	_, err := f.S3.CopyObject(&s3.CopyObjectInput{
-		CopySource: aws.String("/" + f.Bucket + "/" + f.fullPath(path)),
-		Bucket:     aws.String(f.Bucket),
-		Key:        aws.String(f.backupPath(newPath)),
-	})
+func newS3Storage(config *distributionStorageS3) (storageObject, error) {
+	err := checkS3DeleteBatchSize()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return f.Delete(path)
-}
 
-func (f *s3Storage) Info() {
-	logrus.Infoln("S3 INFO: API calls/expensive/free:", f.apiCalls, f.expensiveApiCalls, f.freeApiCalls,
-		"Cache (hit/miss/error):", f.cacheHits, f.cacheMiss, f.cacheError)
-}
-
-func newS3Storage(config *distributionStorageS3) (storageObject, error) {
 	awsConfig := aws.NewConfig()
 	awsConfig.Endpoint = config.RegionEndpoint
 	awsConfig.Region = config.Region
+	awsConfig.S3UseAccelerate = aws.Bool(config.Accelerate)
+	// Retries are handled by s3Throttle
+	awsConfig.MaxRetries = aws.Int(0)
+
+	if config.Secure != nil {
+		awsConfig.DisableSSL = aws.Bool(!*config.Secure)
+	}
 
 	if config.DisableSSL != nil {
 		awsConfig.DisableSSL = config.DisableSSL
@@ -273,18 +208,51 @@ func newS3Storage(config *distributionStorageS3) (storageObject, error) {
 		awsConfig.S3ForcePathStyle = config.ForcePathStyle
 	}
 
-	if config.AccessKey != "" && config.SecretKey != "" {
-		awsConfig.Credentials = credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, "")
+	if config.SkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		awsConfig.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	if config.V4Auth != nil && !*config.V4Auth {
+		logrus.Warningln("S3: v4auth: false is not supported, using V4 signatures")
 	}
 
-	sess, err := session.NewSession()
+	awsConfig.Credentials = config.credentials(awsConfig)
+
+	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	client := s3.New(sess)
+	if config.UserAgent != "" {
+		client.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(config.UserAgent))
+	}
+
 	storage := &s3Storage{
 		distributionStorageS3: config,
-		S3: s3.New(sess, awsConfig),
+		s3Stats:               &s3Stats{},
+		S3:                    client,
+		throttle:              newS3Throttle(),
+		deletedKeys:           &s3KeySet{keys: make(map[string]bool)},
+	}
+
+	if *s3DeleteBatchSize > 1 {
+		storage.deletes = newS3DeleteBatcher(storage.s3Stats, storage.throttle, storage.S3, config.Bucket)
+		if storage.backupBucket() != config.Bucket {
+			storage.backupDeletes = newS3DeleteBatcher(storage.s3Stats, storage.throttle, storage.S3, storage.backupBucket())
+		}
+	}
+
+	storage.detectVersioning()
+
+	if *softDeleteMode == softDeleteModeTag {
+		// Objects can be hidden from registry and kept in place only as noncurrent versions
+		if !storage.versioned {
+			return nil, errors.New("soft-delete-mode tag requires bucket with versioning enabled")
+		}
+		storage.checkExpireLifecycle()
 	}
 	return storage, err
 }
@@ -0,0 +1,33 @@
+package experimental
+
+import "testing"
+
+func TestS3StorageKeys(t *testing.T) {
+	tests := []struct {
+		rootDirectory       string
+		backupRootDirectory string
+		live                string
+		backup              string
+	}{
+		{"", "", "docker/registry/v2/blobs", "docker-backup/registry/v2/blobs"},
+		{"/registry", "", "/registry/docker/registry/v2/blobs", "/registry/docker-backup/registry/v2/blobs"},
+		{"registry", "", "registry/docker/registry/v2/blobs", "registry/docker-backup/registry/v2/blobs"},
+		{"/registry", "/backups", "/registry/docker/registry/v2/blobs", "/backups/docker-backup/registry/v2/blobs"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rootDirectory+":"+test.backupRootDirectory, func(t *testing.T) {
+			setTestFlag(t, backupRootDirectory, test.backupRootDirectory)
+
+			storage := &s3Storage{distributionStorageS3: &distributionStorageS3{RootDirectory: test.rootDirectory}}
+			backup := storage.Backup().(*s3Storage)
+
+			if key := storage.fullPath("blobs"); key != test.live {
+				t.Fatalf("expected live key %q, got %q", test.live, key)
+			}
+			if key := backup.fullPath("blobs"); key != test.backup {
+				t.Fatalf("expected backup key %q, got %q", test.backup, key)
+			}
+		})
+	}
+}
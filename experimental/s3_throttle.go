@@ -0,0 +1,154 @@
+package experimental
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"golang.org/x/time/rate"
+)
+
+const (
+	s3BackoffBase = 100 * time.Millisecond
+	s3BackoffMax  = 20 * time.Second
+)
+
+var (
+	s3MaxRetries     = flag.Int("s3-max-retries", 10, "Number of retries of failed or throttled S3 requests")
+	s3RateLimit      = flag.Float64("s3-rate-limit", 0, "Maximum number of S3 requests per second (0 is unlimited)")
+	s3MaxConcurrency = flag.Int("s3-max-concurrency", 100, "Maximum number of concurrent S3 requests, lowered automatically when S3 throttles")
+)
+
+// s3Throttle retries S3 requests with exponential backoff and jitter,
+// concurrency is adapted with AIMD: increased by one per window of successful requests, halved when throttled,
+// it limits only S3 requests in flight, workers of jobs wait for their turn
+type s3Throttle struct {
+	limiter *rate.Limiter
+
+	lock           sync.Mutex
+	cond           *sync.Cond
+	concurrency    float64
+	maxConcurrency float64
+	inFlight       int
+
+	retries   int64
+	throttles int64
+}
+
+func isS3Throttle(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		switch reqErr.StatusCode() {
+		case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+			return true
+		}
+	}
+
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "SlowDown" {
+		return true
+	}
+	return request.IsErrorThrottle(err)
+}
+
+func isS3Retryable(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+	return request.IsErrorRetryable(err)
+}
+
+func s3Backoff(attempt int) time.Duration {
+	backoff := s3BackoffMax
+	if attempt < 16 {
+		backoff = s3BackoffBase << uint(attempt)
+		if backoff > s3BackoffMax {
+			backoff = s3BackoffMax
+		}
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+func (t *s3Throttle) acquire() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for t.inFlight >= int(t.concurrency) {
+		t.cond.Wait()
+	}
+	t.inFlight++
+}
+
+func (t *s3Throttle) release(throttled bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.inFlight--
+	if throttled {
+		t.concurrency /= 2
+		if t.concurrency < 1 {
+			t.concurrency = 1
+		}
+	} else {
+		t.concurrency += 1 / t.concurrency
+		if t.concurrency > t.maxConcurrency {
+			t.concurrency = t.maxConcurrency
+		}
+	}
+	t.cond.Broadcast()
+}
+
+func (t *s3Throttle) call(fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		if t.limiter != nil {
+			t.limiter.Wait(context.Background())
+		}
+
+		t.acquire()
+		err := fn()
+		throttled := isS3Throttle(err)
+		t.release(throttled)
+
+		if err == nil || attempt >= *s3MaxRetries {
+			return err
+		}
+
+		if throttled {
+			atomic.AddInt64(&t.throttles, 1)
+		} else if !isS3Retryable(err) {
+			return err
+		}
+
+		atomic.AddInt64(&t.retries, 1)
+		time.Sleep(s3Backoff(attempt))
+	}
+}
+
+func (t *s3Throttle) currentConcurrency() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return int(t.concurrency)
+}
+
+func newS3Throttle() *s3Throttle {
+	maxConcurrency := float64(*s3MaxConcurrency)
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+
+	t := &s3Throttle{
+		concurrency:    maxConcurrency,
+		maxConcurrency: maxConcurrency,
+	}
+	t.cond = sync.NewCond(&t.lock)
+
+	if *s3RateLimit > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(*s3RateLimit), 1)
+	}
+	return t
+}
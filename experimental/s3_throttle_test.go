@@ -0,0 +1,138 @@
+package experimental
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestS3Backoff(t *testing.T) {
+	for attempt := 0; attempt < 20; attempt++ {
+		limit := s3BackoffMax
+		if attempt < 8 {
+			limit = s3BackoffBase << uint(attempt)
+		}
+
+		for i := 0; i < 100; i++ {
+			if backoff := s3Backoff(attempt); backoff < 0 || backoff >= limit {
+				t.Fatalf("expected backoff of attempt %d below %v, got %v", attempt, limit, backoff)
+			}
+		}
+	}
+}
+
+func newTestS3Throttle(t *testing.T, maxConcurrency int) *s3Throttle {
+	setTestFlag(t, s3MaxConcurrency, maxConcurrency)
+	setTestFlag(t, s3RateLimit, 0)
+	return newS3Throttle()
+}
+
+func TestS3ThrottleConcurrency(t *testing.T) {
+	throttle := newTestS3Throttle(t, 16)
+
+	throttled := []int{8, 4, 2, 1, 1}
+	for _, expected := range throttled {
+		throttle.acquire()
+		throttle.release(true)
+		if concurrency := throttle.currentConcurrency(); concurrency != expected {
+			t.Fatalf("expected concurrency to be halved to %d, got %d", expected, concurrency)
+		}
+	}
+
+	// concurrency grows by about one after each window of successful requests
+	for i, expected := range []int{2, 2, 2, 3} {
+		throttle.acquire()
+		throttle.release(false)
+		if concurrency := throttle.currentConcurrency(); concurrency != expected {
+			t.Fatalf("expected concurrency %d after %d successful requests, got %d", expected, i+1, concurrency)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		throttle.acquire()
+		throttle.release(false)
+	}
+	if concurrency := throttle.currentConcurrency(); concurrency != 16 {
+		t.Fatal("expected concurrency to grow up to maximum, got:", concurrency)
+	}
+}
+
+func TestS3ThrottleLimitsRequestsInFlight(t *testing.T) {
+	throttle := newTestS3Throttle(t, 3)
+
+	var inFlight, maxInFlight int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			throttle.call(func() error {
+				current := atomic.AddInt32(&inFlight, 1)
+				for {
+					seen := atomic.LoadInt32(&maxInFlight)
+					if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&inFlight, -1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 3 {
+		t.Fatal("expected at most 3 requests in flight, got:", maxInFlight)
+	}
+}
+
+func TestS3ThrottleCall(t *testing.T) {
+	slowDown := awserr.NewRequestFailure(awserr.New("SlowDown", "reduce request rate", nil), http.StatusServiceUnavailable, "")
+	denied := awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), http.StatusForbidden, "")
+
+	tests := []struct {
+		name      string
+		errors    []error
+		calls     int
+		throttles int64
+		err       error
+	}{
+		{"success", []error{nil}, 1, 0, nil},
+		{"throttled and retried", []error{slowDown, slowDown, nil}, 3, 2, nil},
+		{"not retryable", []error{denied, nil}, 1, 0, denied},
+		{"out of retries", []error{slowDown, slowDown, slowDown, slowDown}, 3, 2, slowDown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestFlag(t, s3MaxRetries, 2)
+			throttle := newTestS3Throttle(t, 4)
+
+			calls := 0
+			err := throttle.call(func() error {
+				err := test.errors[calls]
+				calls++
+				return err
+			})
+
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			} else if calls != test.calls {
+				t.Fatalf("expected %d calls, got %d", test.calls, calls)
+			} else if throttle.throttles != test.throttles {
+				t.Fatalf("expected %d throttles, got %d", test.throttles, throttle.throttles)
+			}
+
+			if test.throttles > 0 && throttle.currentConcurrency() >= 4 {
+				t.Fatal("expected concurrency to be lowered when throttled, got:", throttle.currentConcurrency())
+			}
+		})
+	}
+}
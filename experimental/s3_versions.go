@@ -0,0 +1,231 @@
+package experimental
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var s3PurgeVersions = flag.Bool("s3-purge-versions", false, "On versioned S3 bucket permanently remove all versions and delete markers of objects deleted by the run")
+
+type s3ObjectVersion struct {
+	key       string
+	versionID string
+	size      int64
+}
+
+func (f *s3Storage) detectVersioning() {
+	var resp *s3.GetBucketVersioningOutput
+
+	atomic.AddInt64(&f.apiCalls, 1)
+	err := f.throttle.call(func() (err error) {
+		resp, err = f.S3.GetBucketVersioning(&s3.GetBucketVersioningInput{
+			Bucket: aws.String(f.bucket()),
+		})
+		return err
+	})
+	if err != nil {
+		logrus.Warningln("S3: failed to get bucket versioning:", err)
+		return
+	}
+
+	// Suspended versioning still keeps versions created before
+	status := aws.StringValue(resp.Status)
+	f.versioned = status != ""
+
+	if f.versioned {
+		logrus.Infoln("S3: bucket versioning is", status)
+		if !*s3PurgeVersions && *softDeleteMode != softDeleteModeTag {
+			logrus.Warningln("S3: deleted objects are kept as noncurrent versions, use -s3-purge-versions to reclaim space")
+		}
+	}
+}
+
+func (f *s3Storage) deleteVersion(version s3ObjectVersion) error {
+	if f.deletes != nil {
+		return f.deletes.delete(version.key, version.versionID)
+	}
+
+	atomic.AddInt64(&f.freeApiCalls, 1)
+	return f.throttle.call(func() error {
+		_, err := f.S3.DeleteObject(&s3.DeleteObjectInput{
+			Bucket:    aws.String(f.bucket()),
+			Key:       aws.String(version.key),
+			VersionId: aws.String(version.versionID),
+		})
+		return err
+	})
+}
+
+// s3DeletedObject describes all versions of object which latest version is a delete marker
+type s3DeletedObject struct {
+	key      string
+	deleted  bool
+	versions []s3ObjectVersion
+	markers  []s3ObjectVersion
+}
+
+// s3KeySet keeps keys deleted by the run, only their versions are purged
+type s3KeySet struct {
+	keys map[string]bool
+	lock sync.Mutex
+}
+
+func (s *s3KeySet) add(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.keys[key] = true
+}
+
+func (s *s3KeySet) contains(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.keys[key]
+}
+
+// listDeletedObjects lists objects which latest version is a delete marker,
+// versions of object are collected even when they span multiple pages
+func (f *s3Storage) listDeletedObjects(prefix string, fn func(object *s3DeletedObject) error) error {
+	objects := make(map[string]*s3DeletedObject)
+	var keys []string
+
+	objectOf := func(key string) *s3DeletedObject {
+		object := objects[key]
+		if object == nil {
+			object = &s3DeletedObject{key: key}
+			objects[key] = object
+			keys = append(keys, key)
+		}
+		return object
+	}
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(f.bucket()),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(listMax),
+	}
+
+	for {
+		var resp *s3.ListObjectVersionsOutput
+
+		atomic.AddInt64(&f.apiCalls, 1)
+		err := f.throttle.call(func() (err error) {
+			resp, err = f.S3.ListObjectVersions(input)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, marker := range resp.DeleteMarkers {
+			object := objectOf(*marker.Key)
+			object.deleted = object.deleted || aws.BoolValue(marker.IsLatest)
+			object.markers = append(object.markers, s3ObjectVersion{key: *marker.Key, versionID: aws.StringValue(marker.VersionId)})
+		}
+
+		for _, version := range resp.Versions {
+			object := objectOf(*version.Key)
+			object.versions = append(object.versions, s3ObjectVersion{key: *version.Key, versionID: aws.StringValue(version.VersionId), size: aws.Int64Value(version.Size)})
+		}
+
+		// The last key of truncated page can have more versions on the next page
+		var nextKey string
+		if aws.BoolValue(resp.IsTruncated) {
+			nextKey = aws.StringValue(resp.NextKeyMarker)
+		}
+
+		for _, key := range keys {
+			object := objects[key]
+			if key == nextKey || !object.deleted {
+				continue
+			}
+
+			err = fn(object)
+			if err != nil {
+				return err
+			}
+		}
+
+		if nextKey == "" {
+			return nil
+		}
+
+		pending := objects[nextKey]
+		objects = make(map[string]*s3DeletedObject)
+		keys = nil
+		if pending != nil {
+			objects[nextKey] = pending
+			keys = append(keys, nextKey)
+		}
+
+		input.KeyMarker = resp.NextKeyMarker
+		input.VersionIdMarker = resp.NextVersionIdMarker
+	}
+}
+
+func purgeStorageVersions(storage storageObject) error {
+	f, ok := storage.(*s3Storage)
+	if !ok || !*s3PurgeVersions {
+		return nil
+	}
+	return f.purgeVersions()
+}
+
+// purgeObjectVersions removes noncurrent versions before delete markers,
+// removing the marker first would make previous version current again
+func (f *s3Storage) purgeObjectVersions(object *s3DeletedObject) error {
+	path := strings.TrimPrefix(object.key, s3DirPath(f.fullPath("")))
+
+	for _, version := range append(object.versions, object.markers...) {
+		entry := newPlanEntry(path, version.size, "version of deleted object")
+		entry.Kind = "version"
+		entry.ID = version.versionID
+
+		logrus.Infoln("PURGE VERSION", version.key, version.versionID, version.size)
+
+		err := deletePlanned(entry, func() error {
+			return f.deleteVersion(version)
+		})
+		if err != nil {
+			return err
+		}
+
+		countVersionDelete(version.size)
+	}
+	return nil
+}
+
+// purgeVersions removes all versions of objects deleted by the run
+func (f *s3Storage) purgeVersions() error {
+	// Versioning is known only for the registry bucket
+	if !f.versioned || f.bucket() != f.Bucket {
+		return nil
+	}
+
+	logrus.Infoln("Purging S3 VERSIONS...")
+
+	jg := deletesRunner.group()
+
+	err := f.listDeletedObjects(s3DirPath(f.fullPath("")), func(object *s3DeletedObject) error {
+		if !f.deletedKeys.contains(object.key) {
+			return nil
+		}
+
+		jg.dispatch(func() error {
+			return f.purgeObjectVersions(object)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return jg.finish()
+}
@@ -0,0 +1,107 @@
+package experimental
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestS3PurgeObjectVersions(t *testing.T) {
+	key := "docker/registry/v2/blobs/sha256/57/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/data"
+	object := &s3DeletedObject{
+		key:     key,
+		deleted: true,
+		versions: []s3ObjectVersion{
+			{key: key, versionID: "v2", size: 10},
+			{key: key, versionID: "v1", size: 20},
+		},
+		markers: []s3ObjectVersion{
+			{key: key, versionID: "marker2"},
+			{key: key, versionID: "marker1"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		keyErrors map[string]string
+		deleted   string
+		planned   []string
+	}{
+		{
+			name:    "versions before markers",
+			deleted: "[[v2] [v1] [marker2] [marker1]]",
+			planned: []string{"v2", "v1", "marker2", "marker1"},
+		},
+		{
+			name:      "markers are kept when version fails",
+			keyErrors: map[string]string{key + "@v1": "AccessDenied"},
+			deleted:   "[[v2] [v1]]",
+			planned:   []string{"v2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &testDeleteObjects{keyErrors: test.keyErrors}
+			storage := &s3Storage{
+				distributionStorageS3: &distributionStorageS3{Bucket: "registry"},
+				s3Stats:               &s3Stats{},
+				deletes:               newTestDeleteBatcher(t, client, 1, time.Millisecond),
+			}
+
+			planFile := filepath.Join(t.TempDir(), "plan.jsonl")
+			writer, err := newPlanWriter(planFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			setTestFlag(t, &currentPlanWriter, writer)
+			setTestFlag(t, delete, true)
+			setTestFlag(t, &deletedVersions, 0)
+			setTestFlag(t, &deletedBlobSize, 0)
+
+			err = storage.purgeObjectVersions(object)
+			if (err != nil) != (test.keyErrors != nil) {
+				t.Fatal("unexpected purge result:", err)
+			}
+
+			// batches of a single key are sent in order of purge
+			var deleted [][]string
+			for _, batch := range client.batches {
+				var versions []string
+				for _, id := range batch {
+					versions = append(versions, id[len(key)+1:])
+				}
+				deleted = append(deleted, versions)
+			}
+			if fmt.Sprint(deleted) != test.deleted {
+				t.Fatalf("expected versions deleted as %s, got %v", test.deleted, deleted)
+			}
+			if int(deletedVersions) != len(test.planned) {
+				t.Fatal("expected only deleted versions to be counted, got:", deletedVersions)
+			}
+
+			err = writer.close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plan, err := loadDeletionPlan(planFile)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(plan.entries) != len(test.planned) {
+				t.Fatal("expected deleted versions in plan, got:", plan.entries)
+			}
+			for _, versionID := range test.planned {
+				planned := newPlanEntry("blobs/sha256/57/579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1/data", 0, "")
+				planned.ID = versionID
+
+				entry := plan.entries[planned.key()]
+				if entry == nil || entry.Kind != "version" {
+					t.Fatalf("expected version %s in plan, got %v", versionID, plan.entries)
+				}
+			}
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package experimental
 
 import (
+	"flag"
 	"path/filepath"
 	"time"
 )
 
+var backupRootDirectory = flag.String("backup-root-directory", "", "Root directory of soft-deleted data, by default the root directory of registry")
+
 type fileInfo struct {
 	fullPath     string
 	size         int64
@@ -21,11 +24,38 @@ type storageObject interface {
 	Read(path string, etag string) ([]byte, error)
 	Delete(path string) error
 	Move(path, newPath string) error
+	Backup() storageObject
 	Info()
 }
 
+// expiringStorage can soft-delete data in place, to be removed later by the storage itself
+type expiringStorage interface {
+	Expire(path string) error
+	WalkExpired(path string, fn walkFunc) error
+	Unexpire(path string) (bool, error)
+}
+
+// exclusiveStorage moves data only when nothing is stored under new path,
+// storage checks it atomically with the move
+type exclusiveStorage interface {
+	MoveExclusive(path, newPath string) error
+}
+
+// directoryStorage keeps directories, that are left empty when their data is deleted
+type directoryStorage interface {
+	RemoveEmptyDirectory(path string) error
+}
+
 var currentStorage storageObject
 
+// backupRoot returns root directory of soft-deleted data of registry stored in rootDirectory
+func backupRoot(rootDirectory string) string {
+	if *backupRootDirectory != "" {
+		return *backupRootDirectory
+	}
+	return rootDirectory
+}
+
 func parallelWalk(rootPath string, fn func(string) error) error {
 	pwg := parallelWalkRunner.group()
 
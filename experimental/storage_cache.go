@@ -0,0 +1,60 @@
+package experimental
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var storageCacheDir = flag.String("storage-cache", "tmp-cache", "Directory to cache objects downloaded from remote storage")
+
+func init() {
+	// -s3-storage-cache is kept for scripts written before the cache was used by other storages
+	flag.StringVar(storageCacheDir, "s3-storage-cache", *storageCacheDir, "Deprecated alias of -storage-cache")
+}
+
+// storageCache keeps downloaded objects on local disk,
+// objects are validated with md5-based etag, or digest of blob data, on every read
+type storageCache struct {
+	cacheHits  int64
+	cacheError int64
+	cacheMiss  int64
+}
+
+func (c *storageCache) cachePath(path string) string {
+	return filepath.Join(*storageCacheDir, path)
+}
+
+func (c *storageCache) readCache(path string, etag string) []byte {
+	if etag == "" || *storageCacheDir == "" {
+		return nil
+	}
+
+	file, err := ioutil.ReadFile(c.cachePath(path))
+	if err == nil {
+		if compareEtag(file, etag) {
+			atomic.AddInt64(&c.cacheHits, 1)
+			return file
+		} else {
+			atomic.AddInt64(&c.cacheError, 1)
+		}
+	} else if os.IsNotExist(err) {
+		atomic.AddInt64(&c.cacheMiss, 1)
+		logrus.Infoln("CACHE MISS:", path)
+	}
+	return nil
+}
+
+func (c *storageCache) writeCache(path string, etag string, data []byte) {
+	if etag == "" || *storageCacheDir == "" {
+		return
+	}
+
+	cachePath := c.cachePath(path)
+	os.MkdirAll(filepath.Dir(cachePath), 0700)
+	ioutil.WriteFile(cachePath, data, 0600)
+}
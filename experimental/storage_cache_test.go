@@ -0,0 +1,20 @@
+package experimental
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestStorageCacheFlagAlias(t *testing.T) {
+	setTestFlag(t, storageCacheDir, *storageCacheDir)
+
+	for _, name := range []string{"storage-cache", "s3-storage-cache"} {
+		dir := t.TempDir()
+		err := flag.Set(name, dir)
+		if err != nil {
+			t.Fatal(err)
+		} else if *storageCacheDir != dir {
+			t.Fatalf("expected -%s to set cache directory %q, got %q", name, dir, *storageCacheDir)
+		}
+	}
+}
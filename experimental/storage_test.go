@@ -0,0 +1,181 @@
+package experimental
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type testStorageObject struct {
+	path string
+	data string
+}
+
+func testWalk(t *testing.T, storage storageObject, path string) map[string]fileInfo {
+	t.Helper()
+
+	files := make(map[string]fileInfo)
+	err := storage.Walk(path, path, func(path string, info fileInfo, err error) error {
+		files[path] = info
+		return err
+	})
+	if err != nil {
+		t.Fatal("walk:", path, err)
+	}
+	return files
+}
+
+func testWalkPaths(t *testing.T, storage storageObject, path string) []string {
+	t.Helper()
+
+	var paths []string
+	for path := range testWalk(t, storage, path) {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// testStorage exercises storage, put writes object to path relative to root of registry
+func testStorage(t *testing.T, storage storageObject, put func(path string, data []byte) error) {
+	setTestFlag(t, storageCacheDir, t.TempDir())
+
+	layer := "579c7fc9b0d60a19706cd6c1573fec9a28fa758bfe1ece86a1e5c68ad6f4e9d1"
+	manifest := "708519982eae159899e908639f5fa22d23d247ad923f6e6ad6128894c5d497a0"
+
+	objects := []testStorageObject{
+		{"blobs/sha256/57/" + layer + "/data", "layer"},
+		{"blobs/sha256/70/" + manifest + "/data", "manifest"},
+		{"repositories/group/app/_layers/sha256/" + layer + "/link", digestReferenceAlgorithm + layer},
+		{"repositories/group/app/_manifests/revisions/sha256/" + manifest + "/link", digestReferenceAlgorithm + manifest},
+	}
+	for _, object := range objects {
+		err := put(object.path, []byte(object.data))
+		if err != nil {
+			t.Fatal("put:", object.path, err)
+		}
+	}
+
+	t.Run("walk", func(t *testing.T) {
+		files := testWalk(t, storage, "blobs")
+		for _, object := range objects[0:2] {
+			info, ok := files[object.path[len("blobs/"):]]
+			if !ok {
+				t.Fatal("not walked:", object.path, files)
+			}
+
+			hash := md5.Sum([]byte(object.data))
+			if info.size != int64(len(object.data)) || info.lastModified.IsZero() || info.directory {
+				t.Fatalf("unexpected info of %s: %+v", object.path, info)
+			} else if info.etag != "" && info.etag != "\""+hex.EncodeToString(hash[:])+"\"" &&
+				info.etag != digestReferenceAlgorithm+pathDigest(object.path) {
+				t.Fatalf("unexpected etag of %s: %s", object.path, info.etag)
+			}
+		}
+
+		expected := []string{
+			"group/app/_layers/sha256/" + layer + "/link",
+			"group/app/_manifests/revisions/sha256/" + manifest + "/link",
+		}
+		if paths := testWalkPaths(t, storage, "repositories"); !reflect.DeepEqual(paths, expected) {
+			t.Fatalf("expected %v, got %v", expected, paths)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var directories []string
+		err := storage.List("blobs/sha256", func(path string, info fileInfo, err error) error {
+			// directories can be listed with trailing slash, like S3 prefixes
+			if info.directory {
+				directories = append(directories, strings.TrimSuffix(path, "/"))
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sort.Strings(directories)
+		if expected := []string{"57", "70"}; !reflect.DeepEqual(directories, expected) {
+			t.Fatalf("expected %v, got %v", expected, directories)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		info := testWalk(t, storage, "repositories")["group/app/_layers/sha256/"+layer+"/link"]
+
+		// second read can be served from cache
+		for i := 0; i < 2; i++ {
+			data, err := storage.Read(objects[2].path, info.etag)
+			if err != nil || string(data) != objects[2].data {
+				t.Fatalf("expected %q, got %q %v", objects[2].data, data, err)
+			}
+		}
+
+		_, err := storage.Read("repositories/group/app/missing", "")
+		if err == nil {
+			t.Fatal("expected error reading missing object")
+		}
+	})
+
+	t.Run("move", func(t *testing.T) {
+		path := objects[3].path
+		backup := storage.Backup()
+
+		err := storage.Move(path, "backup/"+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := testWalk(t, storage, "repositories")["group/app/_manifests/revisions/sha256/"+manifest+"/link"]; ok {
+			t.Fatal("expected object to be moved out of registry:", path)
+		}
+		if _, ok := testWalk(t, backup, "backup")[path]; !ok {
+			t.Fatal("expected object to be moved to backup:", path)
+		}
+
+		err = restoreMove(storage, backup, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := storage.Read(path, "")
+		if err != nil || string(data) != objects[3].data {
+			t.Fatalf("expected restored %q, got %q %v", objects[3].data, data, err)
+		}
+		if _, ok := testWalk(t, backup, "backup")[path]; ok {
+			t.Fatal("expected object to be removed from backup:", path)
+		}
+
+		// restore never overwrites live data
+		err = storage.Move(path, "backup/"+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = put(path, []byte("pushed again"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = restoreMove(storage, backup, path)
+		if !os.IsExist(err) {
+			t.Fatal("expected restore to refuse overwriting live data, got:", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		err := storage.Delete(objects[2].path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"group/app/_manifests/revisions/sha256/" + manifest + "/link"}
+		if paths := testWalkPaths(t, storage, "repositories"); !reflect.DeepEqual(paths, expected) {
+			t.Fatalf("expected %v, got %v", expected, paths)
+		}
+	})
+}
@@ -3,29 +3,43 @@ package experimental
 import (
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
 type tagData struct {
-	repository *repositoryData
-	name       string
-	current    digest
-	versions   []digest
-	lock       sync.Mutex
+	repository   *repositoryData
+	name         string
+	current      digest
+	versions     []digest
+	lastModified time.Time
+	expired      bool
+	lock         sync.Mutex
+}
+
+func (t *tagData) path() string {
+	return filepath.Join("repositories", t.repository.name, "_manifests", "tags", t.name)
 }
 
 func (t *tagData) currentLinkPath() string {
-	return filepath.Join("repositories", t.repository.name, "_manifests", "tags", t.name, "current", "link")
+	return filepath.Join(t.path(), "current", "link")
 }
 
 func (t *tagData) versionLinkPath(version digest) string {
-	return filepath.Join("repositories", t.repository.name, "_manifests", "tags", t.name, "index", version.path(), "link")
+	return filepath.Join(t.path(), "index", version.path(), "link")
 }
 
 func (t *tagData) mark(blobs blobsData) error {
+	if t.expired {
+		return nil
+	}
+
 	if t.current.valid() {
-		t.repository.markManifest(t.current)
+		err := t.repository.markManifest(t.current)
+		if err != nil {
+			return err
+		}
 	}
 
 	for _, version := range t.versions {
@@ -37,15 +51,22 @@ func (t *tagData) mark(blobs blobsData) error {
 			continue
 		}
 
-		t.repository.markManifest(version)
+		err := t.repository.markManifest(version)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (t *tagData) sweep() error {
+	if t.expired {
+		return t.sweepExpired()
+	}
+
 	if !t.current.valid() {
-		err := deleteFile(t.currentLinkPath(), digestReferenceSize)
+		err := deleteFile(t.currentLinkPath(), digestReferenceSize, "invalid tag")
 		if err != nil {
 			return err
 		}
@@ -57,7 +78,7 @@ func (t *tagData) sweep() error {
 		}
 
 		if *deleteOldTagVersions {
-			err := deleteFile(t.versionLinkPath(version), digestReferenceSize)
+			err := deleteFile(t.versionLinkPath(version), digestReferenceSize, "old tag version")
 			if err != nil {
 				return err
 			}
@@ -66,6 +87,26 @@ func (t *tagData) sweep() error {
 	return nil
 }
 
+func (t *tagData) sweepExpired() error {
+	err := deleteFile(t.currentLinkPath(), digestReferenceSize, "tag expired by policy")
+	if err != nil {
+		return err
+	}
+
+	for _, version := range t.versions {
+		err := deleteFile(t.versionLinkPath(version), digestReferenceSize, "tag expired by policy")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Registry lists tags by their directories
+	if storage, ok := currentStorage.(directoryStorage); ok && *delete {
+		return storage.RemoveEmptyDirectory(t.path())
+	}
+	return nil
+}
+
 func (t *tagData) setCurrent(info fileInfo) error {
 	//INFO[0000] /test2/_manifests/tags/latest/current/link
 
@@ -75,6 +116,7 @@ func (t *tagData) setCurrent(info fileInfo) error {
 	}
 
 	t.current = link
+	t.lastModified = info.lastModified
 	logrus.Infoln("TAG:", t.repository.name, ":", t.name, ": is using:", t.current)
 	return nil
 }
@@ -0,0 +1,155 @@
+package experimental
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// policyDuration is a Go duration, or number of days, as "30d"
+type policyDuration time.Duration
+
+type tagPolicy struct {
+	Repository string         `yaml:"repository"`
+	KeepLast   int            `yaml:"keeplast"`
+	Keep       []string       `yaml:"keep"`
+	OlderThan  policyDuration `yaml:"olderthan"`
+
+	repositoryRegexp *regexp.Regexp
+	keepRegexps      []*regexp.Regexp
+}
+
+type tagPolicies struct {
+	Policies []*tagPolicy `yaml:"policies"`
+}
+
+func parsePolicyDuration(value string) (policyDuration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.ParseUint(days, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return policyDuration(time.Duration(n) * 24 * time.Hour), nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	return policyDuration(duration), err
+}
+
+func (d *policyDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	*d, err = parsePolicyDuration(value)
+	return err
+}
+
+// compileExpression compiles expression that has to match the whole name
+func compileExpression(expression string) (*regexp.Regexp, error) {
+	if expression == "" {
+		return nil, errors.New("empty expression")
+	}
+	return regexp.Compile("^(?:" + expression + ")$")
+}
+
+func (p *tagPolicy) compile() (err error) {
+	p.repositoryRegexp, err = compileExpression(p.Repository)
+	if err != nil {
+		return fmt.Errorf("invalid repository expression %q: %v", p.Repository, err)
+	}
+
+	for _, keep := range p.Keep {
+		keepRegexp, err := compileExpression(keep)
+		if err != nil {
+			return fmt.Errorf("invalid keep expression %q: %v", keep, err)
+		}
+		p.keepRegexps = append(p.keepRegexps, keepRegexp)
+	}
+	return nil
+}
+
+func (p *tagPolicy) keeps(t *tagData) bool {
+	for _, keepRegexp := range p.keepRegexps {
+		if keepRegexp.MatchString(t.name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *tagPolicy) apply(r *repositoryData) {
+	if p.KeepLast <= 0 && p.OlderThan <= 0 {
+		return
+	}
+
+	var candidates []*tagData
+	for _, t := range r.tags {
+		// tags without current link are already removed,
+		// tags with unknown push time are never expired
+		if !t.current.valid() || t.lastModified.IsZero() {
+			continue
+		}
+
+		candidates = append(candidates, t)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastModified.After(candidates[j].lastModified)
+	})
+
+	// Kept tags are counted as the most recent ones too
+	for idx, t := range candidates {
+		if idx < p.KeepLast || p.keeps(t) {
+			continue
+		}
+
+		if p.OlderThan > 0 && time.Since(t.lastModified) <= time.Duration(p.OlderThan) {
+			continue
+		}
+
+		logrus.Infoln("TAG:", r.name, ":", t.name, ": expired by policy, pushed at:", t.lastModified)
+		t.expired = true
+	}
+}
+
+func (p *tagPolicies) find(repository string) *tagPolicy {
+	for _, policy := range p.Policies {
+		if policy.repositoryRegexp.MatchString(repository) {
+			return policy
+		}
+	}
+	return nil
+}
+
+func loadTagPolicies(policyFile string) (*tagPolicies, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := &tagPolicies{}
+	err = yaml.Unmarshal(data, policies)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, policy := range policies.Policies {
+		err = policy.compile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return policies, nil
+}
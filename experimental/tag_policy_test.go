@@ -0,0 +1,263 @@
+package experimental
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTagPolicyCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy tagPolicy
+		valid  bool
+	}{
+		{"valid", tagPolicy{Repository: "group/.*", Keep: []string{"latest"}}, true},
+		{"empty repository", tagPolicy{Repository: "", Keep: []string{"latest"}}, false},
+		{"empty keep", tagPolicy{Repository: "group/.*", Keep: []string{""}}, false},
+		{"invalid repository", tagPolicy{Repository: "group/("}, false},
+		{"invalid keep", tagPolicy{Repository: "group/.*", Keep: []string{"v["}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.compile()
+			if test.valid && err != nil {
+				t.Fatal("unexpected error:", err)
+			} else if !test.valid && err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestTagPoliciesFind(t *testing.T) {
+	policies := &tagPolicies{
+		Policies: []*tagPolicy{
+			{Repository: "group/project"},
+			{Repository: "group/.*|other"},
+		},
+	}
+	for _, policy := range policies.Policies {
+		if err := policy.compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		repository string
+		policy     int
+	}{
+		{"group/project", 0},
+		{"group/project/sub", 1},
+		{"group/other", 1},
+		{"other", 1},
+		{"my/group/project", -1},
+		{"other/group", -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repository, func(t *testing.T) {
+			policy := policies.find(test.repository)
+
+			expected := (*tagPolicy)(nil)
+			if test.policy >= 0 {
+				expected = policies.Policies[test.policy]
+			}
+			if policy != expected {
+				t.Fatalf("expected policy %d, got %v", test.policy, policy)
+			}
+		})
+	}
+}
+
+func TestTagPolicyApply(t *testing.T) {
+	now := time.Now()
+	hours := func(ago int) time.Time {
+		return now.Add(-time.Duration(ago) * time.Hour)
+	}
+
+	// tags pushed from the most recent
+	pushed := map[string]time.Time{
+		"latest": hours(1),
+		"v1.1":   hours(2),
+		"dev-3":  hours(3),
+		"dev-2":  hours(4),
+		"v1.0":   hours(5),
+		"dev-1":  hours(6),
+		"dev-0":  {},
+	}
+
+	tests := []struct {
+		name    string
+		policy  tagPolicy
+		expired []string
+	}{
+		{
+			name:    "no limits",
+			policy:  tagPolicy{Repository: ".*"},
+			expired: nil,
+		},
+		{
+			name:    "keep last",
+			policy:  tagPolicy{Repository: ".*", KeepLast: 3},
+			expired: []string{"dev-1", "dev-2", "v1.0"},
+		},
+		{
+			name:    "kept tags count toward keep last",
+			policy:  tagPolicy{Repository: ".*", KeepLast: 3, Keep: []string{"latest", `v\d+(\.\d+)*`}},
+			expired: []string{"dev-1", "dev-2"},
+		},
+		{
+			name:    "keep expression matches whole name",
+			policy:  tagPolicy{Repository: ".*", KeepLast: 1, Keep: []string{"dev"}},
+			expired: []string{"dev-1", "dev-2", "dev-3", "v1.0", "v1.1"},
+		},
+		{
+			name:    "older than",
+			policy:  tagPolicy{Repository: ".*", OlderThan: policyDuration(210 * time.Minute)},
+			expired: []string{"dev-1", "dev-2", "v1.0"},
+		},
+		{
+			name:    "keep last and older than",
+			policy:  tagPolicy{Repository: ".*", KeepLast: 5, OlderThan: policyDuration(90 * time.Minute)},
+			expired: []string{"dev-1"},
+		},
+		{
+			name:    "kept tags are not expired when older",
+			policy:  tagPolicy{Repository: ".*", Keep: []string{`v\d+(\.\d+)*`}, OlderThan: policyDuration(150 * time.Minute)},
+			expired: []string{"dev-1", "dev-2", "dev-3"},
+		},
+		{
+			name:    "keep, keep last and older than",
+			policy:  tagPolicy{Repository: ".*", KeepLast: 3, Keep: []string{"latest", "v1.0"}, OlderThan: policyDuration(150 * time.Minute)},
+			expired: []string{"dev-1", "dev-2"},
+		},
+		{
+			name:    "older than does not expire recent tags over keep last",
+			policy:  tagPolicy{Repository: ".*", KeepLast: 1, OlderThan: policyDuration(24 * time.Hour)},
+			expired: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			repository := newRepositoryData("group/project")
+			for name, lastModified := range pushed {
+				repository.tags[name] = &tagData{
+					repository:   repository,
+					name:         name,
+					current:      digest{hash: sha256.Sum256([]byte(name))},
+					lastModified: lastModified,
+				}
+			}
+
+			test.policy.apply(repository)
+
+			var expired []string
+			for name, tag := range repository.tags {
+				if tag.expired {
+					expired = append(expired, name)
+				}
+			}
+			sort.Strings(expired)
+
+			if !reflect.DeepEqual(expired, test.expired) {
+				t.Fatalf("expected %v to be expired, got %v", test.expired, expired)
+			}
+		})
+	}
+}
+
+func TestParsePolicyDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		duration time.Duration
+		valid    bool
+	}{
+		{"30d", 30 * 24 * time.Hour, true},
+		{"0d", 0, true},
+		{"720h", 720 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"d", 0, false},
+		{"-1d", 0, false},
+		{"1.5d", 0, false},
+		{"30", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			duration, err := parsePolicyDuration(test.value)
+			if test.valid && err != nil {
+				t.Fatal("unexpected error:", err)
+			} else if !test.valid && err == nil {
+				t.Fatal("expected error, got:", time.Duration(duration))
+			} else if test.valid && time.Duration(duration) != test.duration {
+				t.Fatalf("expected %v, got %v", test.duration, time.Duration(duration))
+			}
+		})
+	}
+}
+
+func TestLoadTagPoliciesDays(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.yml")
+	err := os.WriteFile(policyFile, []byte("policies:\n  - repository: group/.*\n    olderthan: 30d\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := loadTagPolicies(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	} else if olderThan := time.Duration(policies.Policies[0].OlderThan); olderThan != 30*24*time.Hour {
+		t.Fatal("expected 30 days, got:", olderThan)
+	}
+}
+
+func TestTagSweepExpiredRemovesDirectory(t *testing.T) {
+	storage, err := newFilesystemStorage(&distributionStorageFilesystem{RootDirectory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestFlag(t, &currentStorage, storage)
+	setTestFlag(t, delete, true)
+	setTestFlag(t, softDelete, false)
+
+	repository := newRepositoryData("group/project")
+	current := digest{hash: sha256.Sum256([]byte("latest"))}
+	tag := &tagData{repository: repository, name: "latest", current: current, versions: []digest{current}, expired: true}
+	kept := &tagData{repository: repository, name: "stable", current: current}
+
+	for _, path := range []string{tag.currentLinkPath(), tag.versionLinkPath(current), kept.currentLinkPath()} {
+		fullPath := storage.(*fsStorage).livePath(path)
+		err := os.MkdirAll(filepath.Dir(fullPath), 0700)
+		if err == nil {
+			err = os.WriteFile(fullPath, []byte(current.String()), 0600)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tag.sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(storage.(*fsStorage).livePath(tag.path())); !os.IsNotExist(err) {
+		t.Fatal("expected directory of expired tag to be removed:", err)
+	}
+	if _, err := os.Stat(storage.(*fsStorage).livePath(kept.currentLinkPath())); err != nil {
+		t.Fatal("expected other tags to be kept:", err)
+	}
+}
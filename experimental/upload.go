@@ -0,0 +1,97 @@
+package experimental
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type uploadData struct {
+	repository   *repositoryData
+	name         string
+	files        map[string]int64
+	startedAt    time.Time
+	lastModified time.Time
+	lock         sync.Mutex
+}
+
+func (u *uploadData) path(file string) string {
+	return u.repository.uploadPath(u.name, file)
+}
+
+func (u *uploadData) readStartedAt(info fileInfo) (time.Time, error) {
+	// storage usually gives us the modification time, so do not read the file
+	if !info.lastModified.IsZero() {
+		return info.lastModified, nil
+	}
+
+	data, err := currentStorage.Read(u.path("startedat"), info.etag)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
+
+func (u *uploadData) addFile(args []string, info fileInfo) error {
+	// /test/_uploads/f82d2b61-f130-4be5-b4f6-92cb18c7cf89/startedat
+	// /test/_uploads/f82d2b61-f130-4be5-b4f6-92cb18c7cf89/hashstates/sha256/0
+	file := strings.Join(args, "/")
+
+	var startedAt time.Time
+	if file == "startedat" {
+		var err error
+		startedAt, err = u.readStartedAt(info)
+		if err != nil {
+			return err
+		}
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.files[file] = info.size
+	if !startedAt.IsZero() {
+		u.startedAt = startedAt
+	}
+	if info.lastModified.After(u.lastModified) {
+		u.lastModified = info.lastModified
+	}
+	return nil
+}
+
+func (u *uploadData) age() time.Duration {
+	if !u.startedAt.IsZero() {
+		return time.Since(u.startedAt)
+	} else if !u.lastModified.IsZero() {
+		return time.Since(u.lastModified)
+	}
+	return 0
+}
+
+func (u *uploadData) sweep(maxAge time.Duration) error {
+	age := u.age()
+	if age <= maxAge {
+		return nil
+	}
+
+	logrus.Infoln("UPLOAD:", u.repository.name, ":", u.name, ": is stale, started", age, "ago")
+
+	for file, size := range u.files {
+		err := deleteFile(u.path(file), size, "stale upload")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newUploadData(repository *repositoryData, name string) *uploadData {
+	return &uploadData{
+		repository: repository,
+		name:       name,
+		files:      make(map[string]int64),
+	}
+}
@@ -0,0 +1,93 @@
+package experimental
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUploadSweep(t *testing.T) {
+	const day = 24 * time.Hour
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// startedAt is modification time of startedat file, zero when storage does not give it
+		startedAt time.Time
+		// startedAtData is content of startedat file
+		startedAtData string
+		noStartedAt   bool
+		dataModified  time.Time
+		deleted       bool
+	}{
+		{
+			name:         "started before cutoff",
+			startedAt:    now.Add(-10 * day),
+			dataModified: now.Add(-time.Hour),
+			deleted:      true,
+		},
+		{
+			name:         "started after cutoff",
+			startedAt:    now.Add(-6 * day),
+			dataModified: now.Add(-6 * day),
+		},
+		{
+			name:          "started at read from file",
+			startedAtData: now.Add(-10 * day).Format(time.RFC3339),
+			deleted:       true,
+		},
+		{
+			name:          "recent started at read from file",
+			startedAtData: now.Add(-time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:         "without started at",
+			noStartedAt:  true,
+			dataModified: now.Add(-10 * day),
+			deleted:      true,
+		},
+		{
+			name:         "without started at recently modified",
+			noStartedAt:  true,
+			dataModified: now.Add(-time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestManifests(t)
+			setTestFlag(t, delete, true)
+			setTestFlag(t, softDelete, false)
+
+			r := newRepositoryData("group/app")
+			upload := r.upload("f82d2b61-f130-4be5-b4f6-92cb18c7cf89")
+
+			files := map[string]fileInfo{"data": {size: 3, lastModified: test.dataModified}}
+			if !test.noStartedAt {
+				files["startedat"] = fileInfo{size: 20, lastModified: test.startedAt}
+			}
+
+			for file, info := range files {
+				m.put(upload.path(file), test.startedAtData)
+				err := r.addUpload([]string{upload.name, file}, info)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := r.sweepUploads(7 * day)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for file := range files {
+				_, err := os.Stat(m.storage.livePath(upload.path(file)))
+				if test.deleted && !os.IsNotExist(err) {
+					t.Fatal("expected stale upload to be deleted:", file, err)
+				} else if !test.deleted && err != nil {
+					t.Fatal("expected upload to be kept:", file, err)
+				}
+			}
+		})
+	}
+}